@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
+	"sync"
 	"testing"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/kernel"
 	"uk.ac.bris.cs/gameoflife/stubs"
 	// "uk.ac.bris.cs/gameoflife/stubs"
 )
@@ -15,9 +18,8 @@ func BenchmarkGolOffline(b *testing.B) {
 		ImageHeight:   512,
 		Turns:         1000,
 		Threads:       1,
-		ServerAddress: "localhost:8020",
 		VisualUpdates: false,
-		OurIP:         "localhost",
+		Offline:       true,
 	})
 }
 func BenchmarkGolOnline(b *testing.B) {
@@ -87,3 +89,55 @@ func benchmarkGol(b *testing.B, p gol.Params) {
 		}
 	}
 }
+
+// BenchmarkKernels compares the worker kernels on 512x512 and 5120x5120 boards
+// Each iteration works out one turn of the whole board on a single thread, the way a worker does:
+// decoding the halo, running the kernel and encoding the new cells
+func BenchmarkKernels(b *testing.B) {
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	for _, size := range []int{512, 5120} {
+		halo := kernelHalo(size)
+		b.Run(fmt.Sprintf("UpdateRegion-%dx%d", size, size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				wg.Add(1)
+				newBoard := make([][]uint8, size)
+				kernel.UpdateRegion(0, size, halo, newBoard, size, halo.Board.Decode(), nil, rule, stubs.TileMask{}, &wg)
+				stubs.StateBoardFromSlice(newBoard, size, size, 2)
+			}
+		})
+		b.Run(fmt.Sprintf("UpdateRows-%dx%d", size, size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				wg.Add(1)
+				newRows := make([][]uint64, size)
+				rows := halo.Board.Planes[0].DecodeRows(size+2, size)
+				kernel.UpdateRows(0, size, halo, newRows, rows, nil, rule, stubs.TileMask{}, &wg)
+				stubs.StateBoardFromRows(newRows, size, size)
+			}
+		})
+	}
+}
+
+// kernelHalo makes a halo of a whole random board, as the server would send to a single worker
+func kernelHalo(size int) stubs.Halo {
+	board := make([][]bool, size)
+	makeBoard(size, board)
+	cells := make([][]uint8, size+2)
+	for row := range cells {
+		cells[row] = make([]uint8, size)
+		for col := range cells[row] {
+			// The rows above and below the board wrap round
+			if board[(row-1+size)%size][col] {
+				cells[row][col] = 1
+			}
+		}
+	}
+	return stubs.Halo{
+		Board:    stubs.StateBoardFromSlice(cells, size+2, size, 2),
+		Offset:   1,
+		StartPtr: 0,
+		EndPtr:   size,
+		Topology: stubs.Torus,
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestStopOnCycle tests the 64x64 image, which becomes periodic, stops early offline with the same final board.
+func TestStopOnCycle(t *testing.T) {
+	for _, turns := range []int{3001, 3002} {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: turns, Threads: 2, Offline: true}
+		testName := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, p.Turns)
+		t.Run(testName, func(t *testing.T) {
+			expectedAlive, _ := runCycleGame(t, p)
+			p.StopOnCycle = true
+			cells, cycles := runCycleGame(t, p)
+			if cycles != 1 {
+				t.Fatalf("%v cycles were detected, expected 1", cycles)
+			}
+			assertEqualBoard(t, cells, expectedAlive, p)
+		})
+	}
+}
+
+// Run a game, returning the alive cells on the final turn and how many cycles were detected
+func runCycleGame(t *testing.T, p gol.Params) ([]util.Cell, int) {
+	events := make(chan gol.Event)
+	gol.Run(p, events, nil)
+	var cells []util.Cell
+	cycles := 0
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			if e.CompletedTurns != p.Turns {
+				t.Errorf("final turn is %v, expected %v", e.CompletedTurns, p.Turns)
+			}
+			cells = e.Alive
+		case gol.CycleDetected:
+			cycles++
+		}
+	}
+	return cells, cycles
+}
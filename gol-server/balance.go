@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+/////////
+
+// EXTENSION: capacity-aware partitioning
+// Workers are given rows in proportion to how fast they are, so a slow worker doesn't hold up every turn
+// Until a worker has been timed, it is assumed to be as fast as its cores
+
+/////////
+
+// rebalanceInterval is the shortest time between sharing out the workers' strips again
+const rebalanceInterval = 5 * time.Second
+
+// speedSmoothing is how much each new measurement of a worker's speed counts for
+const speedSmoothing = 0.2
+
+// Record how long a worker took to work out some rows
+func (w *worker) recordTurn(rows int, took time.Duration) {
+	if rows == 0 || took <= 0 {
+		return
+	}
+	speed := float64(rows) / took.Seconds()
+	w.speedMutex.Lock()
+	defer w.speedMutex.Unlock()
+	// Smooth out the measurements, since the time for a turn varies with what's on the board
+	if w.speed == 0 {
+		w.speed = speed
+	} else {
+		w.speed += speedSmoothing * (speed - w.speed)
+	}
+}
+
+// Get how many rows a second a worker has been working out, or 0 if it hasn't been timed
+func (w *worker) getSpeed() float64 {
+	w.speedMutex.Lock()
+	defer w.speedMutex.Unlock()
+	return w.speed
+}
+
+// Get how much of the board each worker should be given
+// Workers that haven't been timed yet are guessed from their cores and the speed per core of the others
+func workerWeights(share []*worker) []float64 {
+	weights := make([]float64, len(share))
+	timedSpeed, timedCores := 0.0, 0
+	for i, w := range share {
+		weights[i] = w.getSpeed()
+		if weights[i] > 0 {
+			timedSpeed += weights[i]
+			timedCores += workerCores(w)
+		}
+	}
+	for i, w := range share {
+		if weights[i] > 0 {
+			continue
+		}
+		if timedCores > 0 {
+			weights[i] = timedSpeed / float64(timedCores) * float64(workerCores(w))
+		} else {
+			weights[i] = float64(workerCores(w))
+		}
+	}
+	return weights
+}
+
+// Get a worker's cores, workers that don't say are treated as having one
+func workerCores(w *worker) int {
+	if w.Cores < 1 {
+		return 1
+	}
+	return w.Cores
+}
+
+// Split the rows of the board between workers in proportion to their weights
+// Every worker gets at least one row, so there must be no more workers than rows
+func partitionRows(height int, weights []float64) (starts, ends []int) {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	n := len(weights)
+	starts = make([]int, n)
+	ends = make([]int, n)
+	sum := 0.0
+	for i, weight := range weights {
+		if i > 0 {
+			starts[i] = ends[i-1]
+		}
+		sum += weight
+		end := int(math.Round(float64(height) * sum / total))
+		// Leave at least one row for this worker and each worker after it
+		if end < starts[i]+1 {
+			end = starts[i] + 1
+		}
+		if end > height-(n-1-i) {
+			end = height - (n - 1 - i)
+		}
+		ends[i] = end
+	}
+	return starts, ends
+}
+
+// Returns true if the workers' speeds have changed enough that their strips should be shared out again
+func (g *gameLoop) stripsUnbalanced() bool {
+	set := g.strips
+	if time.Since(set.balancedAt) < rebalanceInterval {
+		return false
+	}
+	starts, ends := partitionRows(g.height, workerWeights(set.workers))
+	for i := range set.workers {
+		rows := set.ends[i] - set.starts[i]
+		change := ends[i] - starts[i] - rows
+		// Ignore small changes, since sharing out the board again takes a while
+		if change < 0 {
+			change = -change
+		}
+		if change > 1 && change > rows/10 {
+			println("Worker", set.workers[i].Address, "should have", ends[i]-starts[i], "rows rather than", rows)
+			return true
+		}
+	}
+	// Don't check again for a while
+	set.balancedAt = time.Now()
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: callback connections
+// Dialling controllers and workers back fails when they are behind NAT or a firewall, so they can open
+// a connection to us for us to make calls on instead
+// These connections start with a preamble, everything else is handled as normal RPC calls to us
+
+/////////
+
+// callbackWait is how long StartGame and ConnectWorker wait for a callback connection to arrive
+// It is also how long a callback connection waits for the call that wants it, before it is closed
+const callbackWait = 2 * time.Second
+
+// callbackCheck is how often a callback connection that arrived early looks for the call that wants it
+const callbackCheck = 10 * time.Millisecond
+
+var (
+	// callbacks holds a channel for each token a call is waiting for a callback connection with
+	callbacks      = make(map[string]chan net.Conn)
+	callbacksMutex sync.Mutex
+)
+
+// bufferedConn is a connection which has had some of its input read into a buffer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read from the buffer first, then the connection
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Accept connections until the listener is closed, serving RPC calls on them or keeping them as callbacks
+func serveConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			println("Stopped accepting connections:", err.Error())
+			return
+		}
+		go serveConnection(conn)
+	}
+}
+
+// Serve RPC calls on a connection, unless it is a callback connection
+func serveConnection(conn net.Conn) {
+	buffered := &bufferedConn{Conn: conn, reader: bufio.NewReader(conn)}
+	start, err := buffered.reader.Peek(len(stubs.CallbackPreamble))
+	if err != nil || string(start) != stubs.CallbackPreamble {
+		// Anything else is a normal RPC connection
+		rpc.ServeConn(buffered)
+		return
+	}
+	line, err := buffered.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(line, stubs.CallbackPreamble))
+	// The connection is usually opened before the call that wants it, so give the call time to arrive
+	deadline := time.Now().Add(callbackWait)
+	for time.Now().Before(deadline) {
+		// The connection is passed on while holding the mutex, so it can't arrive after the call has given up
+		callbacksMutex.Lock()
+		waiting, found := callbacks[token]
+		passed := false
+		if found {
+			select {
+			case waiting <- buffered:
+				passed = true
+			default:
+			}
+		}
+		callbacksMutex.Unlock()
+		if passed {
+			return
+		}
+		if found {
+			// A connection has already been passed on with this token
+			println("Closing duplicate callback connection")
+			conn.Close()
+			return
+		}
+		time.Sleep(callbackCheck)
+	}
+	println("Nothing wanted the callback connection, closing it")
+	conn.Close()
+}
+
+// Get a client for a controller or worker, using its callback connection if it has one
+// Otherwise, or if the callback connection doesn't arrive in time, it is dialled at its address
+func dialBack(token, address string) (*rpc.Client, error) {
+	if token != "" {
+		// Only this call makes the channel, so connections with tokens nothing is waiting for are closed
+		waiting := make(chan net.Conn, 1)
+		callbacksMutex.Lock()
+		callbacks[token] = waiting
+		callbacksMutex.Unlock()
+		select {
+		case conn := <-waiting:
+			callbacksMutex.Lock()
+			delete(callbacks, token)
+			callbacksMutex.Unlock()
+			return rpc.NewClient(conn), nil
+		case <-time.After(callbackWait):
+			callbacksMutex.Lock()
+			delete(callbacks, token)
+			callbacksMutex.Unlock()
+			println("Callback connection didn't arrive, dialling", address)
+			// A connection may have been passed on just before we gave up
+			select {
+			case conn := <-waiting:
+				conn.Close()
+			default:
+			}
+		}
+	}
+	return rpc.Dial("tcp", address)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// Open a callback connection to serveConnection with a token, returning our end of it
+func openCallback(token string) net.Conn {
+	ours, theirs := net.Pipe()
+	go serveConnection(theirs)
+	// The write returns once the server has read the preamble
+	ours.Write([]byte(stubs.CallbackPreamble + token + "\n"))
+	return ours
+}
+
+// Check a connection is closed by the server within the callback wait
+func assertClosed(t *testing.T, conn net.Conn, reason string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(callbackWait + time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("%v connection wasn't closed: %v", reason, err)
+	}
+}
+
+// TestCallbackConnections checks a callback connection is passed to the call waiting for it,
+// and connections with unknown or used tokens are closed rather than kept
+func TestCallbackConnections(t *testing.T) {
+	// The connection arrives before the call that wants it, like it does from controllers
+	first := openCallback("used")
+	defer first.Close()
+	client, err := dialBack("used", "127.0.0.1:1")
+	if err != nil {
+		t.Fatal("callback connection wasn't used:", err)
+	}
+	defer client.Close()
+
+	second := openCallback("used")
+	unknown := openCallback("unknown")
+	assertClosed(t, second, "duplicate")
+	assertClosed(t, unknown, "unknown")
+
+	callbacksMutex.Lock()
+	left := len(callbacks)
+	callbacksMutex.Unlock()
+	if left != 0 {
+		t.Errorf("%v callback tokens are still registered, expected none", left)
+	}
+}
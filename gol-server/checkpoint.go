@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: checkpoints
+// Each session's game is saved to disk regularly, so it can be resumed after the server restarts
+// Checkpoints are gob files named session-<ID>.gob in the checkpoint directory
+
+/////////
+
+// checkpoint is everything needed to resume a session's game
+type checkpoint struct {
+	SessionID string
+	Order     int
+	Turn      int
+	MaxTurns  int
+	Height    int
+	Width     int
+	Rule      string
+	Topology  string
+	Board     *stubs.StateBoard
+	Time      time.Time
+}
+
+var (
+	// checkpointDir is where checkpoints are saved, they are turned off if this is empty
+	checkpointDir string
+	// checkpointInterval is how often a running game is saved
+	checkpointInterval time.Duration
+	// checkpointMutex makes sure only one checkpoint is written at a time
+	checkpointMutex sync.Mutex
+)
+
+// Get the path of a session's checkpoint file
+func checkpointPath(id string) string {
+	return filepath.Join(checkpointDir, "session-"+id+".gob")
+}
+
+// Make a checkpoint of a session's game
+// This copies the board, so the checkpoint can be written while the game carries on
+func (s *session) makeCheckpoint() checkpoint {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return checkpoint{
+		SessionID: s.ID,
+		Order:     s.Order,
+		Turn:      s.Turn,
+		MaxTurns:  s.Info.MaxTurns,
+		Height:    s.Info.Height,
+		Width:     s.Info.Width,
+		Rule:      s.Info.Rule.String(),
+		Topology:  s.Info.Topology.String(),
+		Board:     stubs.StateBoardFromSlice(s.Board, s.Info.Height, s.Info.Width, s.Info.Rule.NumStates()),
+		Time:      time.Now(),
+	}
+}
+
+// Write a checkpoint to disk
+// It is written to a temporary file first, so a crash while writing never loses the previous checkpoint
+func writeCheckpoint(c checkpoint) {
+	if checkpointDir == "" {
+		return
+	}
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+	err := os.MkdirAll(checkpointDir, os.ModePerm)
+	if err != nil {
+		println("Error writing checkpoint:", err.Error())
+		return
+	}
+	path := checkpointPath(c.SessionID)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		println("Error writing checkpoint:", err.Error())
+		return
+	}
+	err = gob.NewEncoder(file).Encode(c)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		println("Error writing checkpoint:", err.Error())
+		os.Remove(path + ".tmp")
+		return
+	}
+	println("Saved checkpoint of session", c.SessionID, "at turn", c.Turn)
+}
+
+// Remove a session's checkpoint, once the session itself has been removed
+func removeCheckpoint(id string) {
+	if checkpointDir == "" {
+		return
+	}
+	os.Remove(checkpointPath(id))
+}
+
+// Load every checkpoint in the checkpoint directory as a finished session
+// The most recent one will be resumed by a controller that doesn't give a session
+func loadCheckpoints() {
+	if checkpointDir == "" {
+		return
+	}
+	paths, _ := filepath.Glob(filepath.Join(checkpointDir, "session-*.gob"))
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			println("Error loading checkpoint:", err.Error())
+			continue
+		}
+		var c checkpoint
+		err = gob.NewDecoder(file).Decode(&c)
+		file.Close()
+		if err != nil {
+			println("Error loading checkpoint", path+":", err.Error())
+			continue
+		}
+		rule, err := stubs.ParseRule(c.Rule)
+		if err != nil {
+			println("Error loading checkpoint", path+": invalid rule", c.Rule)
+			continue
+		}
+		topology, err := stubs.ParseTopology(c.Topology)
+		if err != nil {
+			println("Error loading checkpoint", path+": invalid topology", c.Topology)
+			continue
+		}
+
+		// Recreate the session as if its game had just finished
+		s := &session{
+			ID:          c.SessionID,
+			Order:       c.Order,
+			Keypresses:  make(chan rune, 10),
+			RunTurns:    make(chan int, 10),
+			Subscribers: make(map[*subscriber]bool),
+			Info: gameInfo{
+				MaxTurns: c.MaxTurns,
+				Height:   c.Height,
+				Width:    c.Width,
+				Rule:     rule,
+				Topology: topology,
+			},
+			Board: c.Board.ToSlice(),
+			Turn:  c.Turn,
+		}
+		sessions[s.ID] = s
+		// New sessions must not reuse the IDs of loaded ones
+		if id, err := strconv.Atoi(s.ID); err == nil && id >= nextSession {
+			nextSession = id + 1
+		}
+		if s.Order >= nextSession {
+			nextSession = s.Order + 1
+		}
+		println("Loaded checkpoint of session", s.ID, "at turn", s.Turn, "from", c.Time.Format(time.RFC3339))
+	}
+	pruneSessions()
+}
@@ -0,0 +1,12 @@
+package main
+
+/////////
+
+// EXTENSION: cycle detection
+// Each turn's board is hashed with util.HashBoard, so we can tell when a game becomes static (a still life) or periodic
+// Only the hashes of recent turns are kept, so cycles longer than the window aren't found
+
+/////////
+
+// cycleWindow is how many turns of hashes are kept, cycle detection is off if this is 0
+var cycleWindow int
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: turn history
+// The most recent boards of a game are kept, so a paused game can be stepped backwards and forwards
+
+/////////
+
+// historyLength is how many turns are kept in each game's history, it is off if this is 0
+var historyLength int
+
+// history is a ring buffer of the boards of consecutive turns
+// Boards are stored as StateBoards, so each takes about as much space as a BitBoard
+type history struct {
+	boards []*stubs.StateBoard
+	// start is the index of the oldest board in the ring
+	start int
+	// length is the number of boards in the ring
+	length int
+	// firstTurn is the turn of the oldest board
+	firstTurn int
+}
+
+// Make an empty history which can store up to size turns
+func newHistory(size int) *history {
+	return &history{boards: make([]*stubs.StateBoard, size)}
+}
+
+// Add the board for a turn to the history
+// Any boards after this turn are removed, so the history always ends with this turn
+// If the turn doesn't follow on from the history, it starts again from this turn
+func (h *history) add(turn int, board [][]uint8, rule stubs.Rule) {
+	size := len(h.boards)
+	if size == 0 {
+		return
+	}
+	if h.length == 0 || turn < h.firstTurn || turn > h.firstTurn+h.length {
+		// Start a new history
+		h.start = 0
+		h.length = 0
+		h.firstTurn = turn
+	} else {
+		// Forget any turns from this one onwards
+		h.length = turn - h.firstTurn
+	}
+	// Overwrite the oldest turn if the ring is full
+	if h.length == size {
+		h.start = (h.start + 1) % size
+		h.firstTurn++
+		h.length--
+	}
+	height := len(board)
+	width := len(board[0])
+	h.boards[(h.start+h.length)%size] = stubs.StateBoardFromSlice(board, height, width, rule.NumStates())
+	h.length++
+}
+
+// Get the board for a turn
+// Returns false if the turn isn't in the history
+func (h *history) get(turn int) ([][]uint8, bool) {
+	if turn < h.firstTurn || turn >= h.firstTurn+h.length {
+		return nil, false
+	}
+	return h.boards[(h.start+turn-h.firstTurn)%len(h.boards)].ToSlice(), true
+}
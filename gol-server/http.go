@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/pattern"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+/////////
+
+// EXTENSION: HTTP API
+// This file lets games be started and controlled with JSON requests, e.g. using curl
+// It does the same things as the controller's RPC calls in server.go
+
+/////////
+
+// sendWait is how long a request waits for a game to take a keypress or command
+const sendWait = time.Second
+
+// httpResponse is the JSON returned by any request that performs an action
+type httpResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// Session is the ID of the session a game was started in
+	Session string `json:"session,omitempty"`
+}
+
+// httpStatus is the JSON returned by GET /game for each session
+type httpStatus struct {
+	Session       string `json:"session"`
+	Running       bool   `json:"running"`
+	Paused        bool   `json:"paused"`
+	HasController bool   `json:"controller"`
+	Turn          int    `json:"turn"`
+	MaxTurns      int    `json:"maxTurns"`
+	AliveCells    int    `json:"aliveCells"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Rule          string `json:"rule"`
+	Topology      string `json:"topology"`
+	// CyclePeriod is 0 until the board is found to be static or periodic
+	CycleStart  int `json:"cycleStart"`
+	CyclePeriod int `json:"cyclePeriod"`
+	// The tiles the workers worked out and skipped on the last turn
+	TilesComputed int `json:"tilesComputed"`
+	TilesSkipped  int `json:"tilesSkipped"`
+	// The fragments sent to a second worker because the first was too slow
+	Speculations int `json:"speculativeRetries"`
+}
+
+// httpWorker is the JSON for each worker returned by GET /workers
+type httpWorker struct {
+	Address string `json:"address"`
+	Cores   int    `json:"cores"`
+	// Speed is 0 until the worker has worked out a turn
+	Speed float64 `json:"rowsPerSecond"`
+}
+
+// httpStartRequest is the JSON sent to POST /game/start
+// If there is no pattern the board is randomised, unless resuming the last game
+type httpStartRequest struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Turns    int    `json:"turns"`
+	Threads  int    `json:"threads"`
+	Rule     string `json:"rule"`
+	Topology string `json:"topology"`
+	// Pattern is an RLE pattern to place in the middle of the board
+	Pattern string `json:"pattern"`
+	// Resume continues the last game instead of starting a new one
+	// Any settings that aren't given are the same as the previous game
+	Resume bool `json:"resume"`
+	// Session is the session to resume, if empty the most recent one is resumed
+	Session string `json:"session"`
+	// StopOnCycle ends the game early once the board is static or periodic
+	StopOnCycle bool `json:"stopOnCycle"`
+}
+
+// httpKeys maps each action to the keypress that performs it
+var httpKeys = map[string]rune{
+	"pause":  'p',
+	"resume": 'p',
+	"save":   's',
+	"quit":   'q',
+	"kill":   'k',
+	// Stepping only works while paused
+	"back":    '<',
+	"forward": '>',
+	"step":    'n',
+}
+
+// Returns the handler for all HTTP API requests
+func httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/game", handleStatus)
+	mux.HandleFunc("/sessions", handleSessions)
+	mux.HandleFunc("/game/start", handleStart)
+	mux.HandleFunc("/game/run", handleRun)
+	mux.HandleFunc("/workers", handleWorkers)
+	mux.HandleFunc("/stream", handleStream)
+	mux.HandleFunc("/", handleViewer)
+	for action := range httpKeys {
+		mux.HandleFunc("/game/"+action, handleAction)
+	}
+	return mux
+}
+
+// Write a value to the response as JSON
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Write a failed action to the response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, httpResponse{Success: false, Message: message})
+}
+
+// Find the session a request is for, from its "session" query parameter
+// If there isn't one the most recent session is used
+// Writes an error and returns nil if there is no such session
+func requestSession(w http.ResponseWriter, r *http.Request) *session {
+	id := r.URL.Query().Get("session")
+	s := getSession(id)
+	if s == nil {
+		if id == "" {
+			writeError(w, http.StatusNotFound, "No games have been started")
+		} else {
+			writeError(w, http.StatusNotFound, "No session "+id)
+		}
+	}
+	return s
+}
+
+// Get the status of a session's game
+func (s *session) status() httpStatus {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	status := httpStatus{
+		Session:       s.ID,
+		Running:       s.Info.Running,
+		Paused:        s.Info.Paused,
+		HasController: s.Controller != nil,
+		Turn:          s.Turn,
+		MaxTurns:      s.Info.MaxTurns,
+		Width:         s.Info.Width,
+		Height:        s.Info.Height,
+		CycleStart:    s.Info.CycleStart,
+		CyclePeriod:   s.Info.CyclePeriod,
+		TilesComputed: s.Info.Tiles.Computed,
+		TilesSkipped:  s.Info.Tiles.Skipped,
+		Speculations:  s.Info.Speculations,
+	}
+	// Only fill in the board details once the game has started
+	if s.Board != nil {
+		status.AliveCells = len(util.GetAliveCells(s.Board))
+		status.Rule = s.Info.Rule.String()
+		status.Topology = s.Info.Topology.String()
+	}
+	return status
+}
+
+// GET /game?session=ID returns the state of a session's game
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	s := requestSession(w, r)
+	if s == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+// GET /sessions returns the state of every session's game
+func handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	list := make([]httpStatus, 0)
+	for _, s := range allSessions() {
+		list = append(list, s.status())
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GET /workers returns the address of every connected worker
+func handleWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	workersMutex.Lock()
+	list := make([]httpWorker, len(workers))
+	for i, worker := range workers {
+		list[i] = httpWorker{Address: worker.Address, Cores: worker.Cores, Speed: worker.getSpeed()}
+	}
+	workersMutex.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST /game/pause, /game/resume, /game/save, /game/quit, /game/kill, /game/back, /game/forward and /game/step
+// (with ?session=ID) send the same keypresses as the controller's SDL window
+func handleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Use POST")
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, "/game/")
+	s := requestSession(w, r)
+	if s == nil {
+		return
+	}
+	s.Mutex.Lock()
+	running, paused := s.Info.Running, s.Info.Paused
+	s.Mutex.Unlock()
+
+	// Keypresses are only read while a game is running
+	if !running {
+		writeError(w, http.StatusConflict, "No game is running")
+		return
+	}
+	// Pausing toggles, so make sure it goes the way that was asked
+	switch {
+	case action == "pause" && paused:
+		writeError(w, http.StatusConflict, "Game is already paused")
+		return
+	case action == "resume" && !paused:
+		writeError(w, http.StatusConflict, "Game is not paused")
+		return
+	case (action == "back" || action == "forward" || action == "step") && !paused:
+		writeError(w, http.StatusConflict, "Game must be paused to step through it")
+		return
+	}
+
+	println("Received", action, "request over HTTP for session", s.ID)
+	// The game may have ended since we checked, and then nothing will take the keypress
+	select {
+	case s.Keypresses <- httpKeys[action]:
+	case <-time.After(sendWait):
+		writeError(w, http.StatusConflict, "Game is no longer running")
+		return
+	}
+	writeJSON(w, http.StatusOK, httpResponse{Success: true, Message: "Sent " + action})
+}
+
+// POST /game/run?turns=N (with &session=ID) runs N more turns and then pauses again
+// It works whether or not the game is paused
+func handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Use POST")
+		return
+	}
+	turns, err := strconv.Atoi(r.URL.Query().Get("turns"))
+	if err != nil || turns <= 0 {
+		writeError(w, http.StatusBadRequest, "turns must be a positive number")
+		return
+	}
+	s := requestSession(w, r)
+	if s == nil {
+		return
+	}
+	if !s.IsRunning() {
+		writeError(w, http.StatusConflict, "No game is running")
+		return
+	}
+
+	println("Received request over HTTP to run", turns, "turns in session", s.ID)
+	select {
+	case s.RunTurns <- turns:
+	case <-time.After(sendWait):
+		writeError(w, http.StatusConflict, "Game is no longer running")
+		return
+	}
+	writeJSON(w, http.StatusOK, httpResponse{Success: true, Message: "Running " + strconv.Itoa(turns) + " turns", Session: s.ID})
+}
+
+// POST /game/start starts a game without a controller, in a new session unless resuming
+func handleStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Use POST")
+		return
+	}
+	req := httpStartRequest{Threads: 8}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	println("Received request to start a game over HTTP")
+
+	workersMutex.Lock()
+	numWorkers := len(workers)
+	workersMutex.Unlock()
+	if numWorkers == 0 && !useLocalEngine {
+		writeError(w, http.StatusServiceUnavailable, "Server has no workers")
+		return
+	}
+
+	// Resumed games keep their previous settings, unless the request changes them
+	if req.Resume {
+		if previous := getResumableSession(req.Session); previous != nil {
+			previous.Mutex.Lock()
+			if req.Width == 0 && req.Height == 0 {
+				req.Width, req.Height = previous.Info.Width, previous.Info.Height
+			}
+			if req.Rule == "" {
+				req.Rule = previous.Info.Rule.String()
+			}
+			if req.Topology == "" {
+				req.Topology = previous.Info.Topology.String()
+			}
+			if req.Turns == 0 {
+				req.Turns = previous.Info.MaxTurns
+			}
+			previous.Mutex.Unlock()
+		}
+	}
+
+	// Read the pattern first, it might give the rule and size
+	var p *pattern.Pattern
+	if req.Pattern != "" {
+		var err error
+		p, err = pattern.ReadRLE(strings.NewReader(req.Pattern))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pattern: "+err.Error())
+			return
+		}
+		if req.Rule == "" {
+			req.Rule = p.Rule
+		}
+		if req.Width == 0 && req.Height == 0 {
+			req.Width, req.Height = p.Width, p.Height
+		}
+	}
+	if req.Rule == "" {
+		req.Rule = stubs.DefaultRule
+	}
+	if req.Width == 0 && req.Height == 0 {
+		req.Width, req.Height = 512, 512
+	}
+
+	// Check everything is valid before starting
+	rule, err := stubs.ParseRule(req.Rule)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid rule \""+req.Rule+"\": "+err.Error())
+		return
+	}
+	topology, err := stubs.ParseTopology(req.Topology)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid topology \""+req.Topology+"\": "+err.Error())
+		return
+	}
+	if req.Width <= 0 || req.Height <= 0 {
+		writeError(w, http.StatusBadRequest, "Width and height must be positive")
+		return
+	}
+	if req.Turns <= 0 {
+		writeError(w, http.StatusBadRequest, "Turns must be positive")
+		return
+	}
+	if req.Threads <= 0 {
+		writeError(w, http.StatusBadRequest, "Threads must be positive")
+		return
+	}
+
+	var game *session
+	var board [][]uint8
+	startTurn := 0
+	if req.Resume {
+		game, board, startTurn, err = resumeSession(req.Session, req.Height, req.Width)
+		if err != nil {
+			writeError(w, http.StatusConflict, "Error resuming: "+err.Error())
+			return
+		}
+	} else if p == nil {
+		board = make([][]uint8, req.Height)
+		for row := range board {
+			board[row] = make([]uint8, req.Width)
+		}
+		randomiseBoard(board, req.Height, req.Width)
+	} else {
+		// Place the pattern in the middle of the board, the same way the controller does
+		if err := pattern.CheckStates(p, rule.NumStates()); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pattern: "+err.Error())
+			return
+		}
+		board, err = pattern.Place(p, req.Width, req.Height, -1, -1)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid pattern: "+err.Error())
+			return
+		}
+	}
+
+	// The board is ready, so make a session for it
+	if game == nil {
+		game = newSession()
+	}
+	println("Starting a game over HTTP in session", game.ID)
+	startSession(game, nil, board, startTurn, req.Height, req.Width, req.Turns, req.Threads, false, req.StopOnCycle, rule, topology)
+	writeJSON(w, http.StatusOK, httpResponse{Success: true, Message: "Started!", Session: game.ID})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"runtime"
+
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: local engine
+// While no workers are connected, we work out turns ourselves with the same code as the workers,
+// so games can start without workers and keep going when the last one drops
+// Once a worker connects, turns are handed back to the workers
+
+/////////
+
+var (
+	// useLocalEngine lets us work out turns ourselves while there are no workers
+	useLocalEngine bool
+)
+
+// Returns true if there are no workers, so we should work out turns ourselves
+func workLocally() bool {
+	if !useLocalEngine {
+		return false
+	}
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	return len(workers) == 0
+}
+
+// Work out the next turn of the whole board ourselves, with a thread for each of our cores
+// Only the active tiles are worked out, and the tiles that changed are marked in the tile map
+func updateBoardLocally(board [][]uint8, newBoard [][]uint8, height, width int, rule stubs.Rule, topology stubs.Topology, tiles *tileMap) {
+	halo := kernel.MakeHalo(0, height, height, width, board, topology, rule)
+	frag, changed := kernel.DoTurn(halo, runtime.NumCPU(), rule, tiles.mask(0, height))
+	cells := frag.Board.ToSlice()
+	for row := 0; row < height; row++ {
+		copy(newBoard[row], cells[row])
+	}
+	tiles.clearChanged()
+	tiles.mark(changed)
+	tiles.advance()
+}
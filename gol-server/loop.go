@@ -3,9 +3,15 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"uk.ac.bris.cs/gameoflife/hashlife"
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/pattern"
 	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
@@ -16,301 +22,646 @@ import (
 
 /////////
 
+// attempt is the result of sending a fragment to a worker
+// A fragment may be sent to more than one worker if the first is too slow
+type attempt struct {
+	frag     int
+	worker   *worker
+	response stubs.DoTurnResponse
+	err      error
+}
+
 // Send a portion of the board to a worker to process the turn for
-// When we get a fragment back, send it down the frag channel
-func doWorker(halo stubs.Halo, newBoard [][]bool, threads int, worker *worker, failChan chan<- bool, fragChan chan<- stubs.Fragment) {
-	response := stubs.DoTurnResponse{}
+// When we get a fragment back (with the tiles that changed), or an error, send it down the results channel
+func doWorker(frag int, halo stubs.Halo, threads int, rule stubs.Rule, active stubs.TileMask, worker *worker, results chan<- attempt) {
+	result := attempt{frag: frag, worker: worker}
 
 	// Send the halo to the client, get the result
-	err := worker.Client.Call(stubs.WorkerDoTurn,
-		stubs.DoTurnRequest{Halo: halo, Threads: threads}, &response)
-	if err != nil {
-		println("Error getting fragment:", err.Error())
-		// If we encounter an error then set the fail flag to true
-		// Lock the mutex here to get exclusive access
-		// Disconnect the worker
+	// EXTENSION: giving up if it takes far longer than it should, since the worker may have hung
+	start := time.Now()
+	rows := halo.EndPtr - halo.StartPtr
+	var response stubs.DoTurnResponse
+	result.err = callWorker(worker, stubs.WorkerDoTurn,
+		stubs.DoTurnRequest{Halo: halo, Threads: threads, Rule: rule, Active: active}, &response, callTimeout(worker, rows))
+	if result.err != nil {
+		println("Error getting fragment:", result.err.Error())
+		// If we encounter an error then disconnect the worker
 		disconnectWorker(worker)
-		failChan <- true
-		return
-	}
-	fragChan <- response.Frag
-}
-
-// Create a "halo" of cells containing only the cells required to calculat the next turn
-// Take the whole board and return a halo which can be passed to a worker
-func makeHalo(worker int, fragHeight int, numWorkers int, height, width int, board [][]bool) stubs.Halo {
-	// This will hold all the cells that will be stored in  the halo
-	cells := make([][]bool, 0)
-
-	// Find the boundaries for this worker
-	start := worker * fragHeight
-	end := (worker + 1) * fragHeight
-	if worker == numWorkers-1 {
-		end = height
-	}
-
-	// DownPtr and UpPtr point to the rows of the board below and abole the boundary this worker calculates for
-	downPtr := end % height // "max row + 1"
-	upPtr := (start - 1)    // "min row - 1"
-	if upPtr == -1 {
-		upPtr = height - 1
-	}
-	// WorkPtr points to the the row the worker should start calculating new cells for
-	workPtr := 0
-
-	// Add the UpPtr row if wouldn't be in the board anyway
-	if upPtr != end-1 {
-		cells = append(cells, board[upPtr])
-		workPtr = 1
-	}
-	// Add rows we want to calculate the next turn of
-	for row := start; row < end; row++ {
-		cells = append(cells, board[row])
-	}
-	// Add the DownPtr row if isn't included in the board
-	if downPtr != start {
-		cells = append(cells, board[downPtr])
-	}
-	// Return a new halo for these cells
-	return stubs.Halo{
-		BitBoard: stubs.BitBoardFromSlice(cells, len(cells), width), // Convert the grid into a bitboard
-		Offset:   workPtr,
-		StartPtr: start,
-		EndPtr:   end,
+	} else {
+		result.response = response
+		// Time the worker, so it can be given the right number of rows
+		worker.recordTurn(rows, time.Since(start))
 	}
+	results <- result
 }
 
 // Update board is called every time we want to process a turn
 // This will partition the board up and send each fragment to a worker
 // Workers will copy the new turn onto the newBoard slice
-// Returns true if there have been no errors (and the whole board has been set)
-func updateBoard(board [][]bool, newBoard [][]bool, height, width int, threads int) bool {
-	// Create a WaitGroup so we only return when all workers have finished
+// Only the active tiles are worked out, and the tiles that changed are marked in the tile map
+// EXTENSION: if a worker takes too long, its fragment is also sent to an idle worker and whichever is first is used
+// EXTENSION: if a worker fails, only its fragment is sent to another worker, rather than working out the whole turn again
+// Returns true if there have been no errors (and the whole board has been set),
+// along with the number of fragments sent to a second worker
+func updateBoard(s *session, board [][]uint8, newBoard [][]uint8, height, width int, threads int, rule stubs.Rule, topology stubs.Topology, tiles *tileMap) (bool, int) {
+	// Create a WaitGroup so we only send fragments once all the halos are made
 	var wg sync.WaitGroup
-	// EXTENSION: Worker goroutines will flag if a worker fails to communicate
-	// We can then disconnect the worker and retry the turn
-	// Assume we start with no fails
-	failChan := make(chan bool)
 	// Lock workers so no new workers can be added / removed until all goroutines are started
 	workersMutex.Lock()
 
-	// Calculate the number of rows each worker thread should use
-	numWorkers := len(workers)
+	// EXTENSION: only use this session's share of the workers
+	sessionWorkers := sessionWorkers(s)
+	// Every worker needs at least one row
+	if len(sessionWorkers) > height {
+		sessionWorkers = sessionWorkers[:height]
+	}
+	numWorkers := len(sessionWorkers)
 	// Bail if we have no workers
 	if numWorkers == 0 {
-		return false
+		workersMutex.Unlock()
+		// EXTENSION: unless we can work out the turn ourselves
+		if useLocalEngine {
+			updateBoardLocally(board, newBoard, height, width, rule, topology, tiles)
+			return true, 0
+		}
+		return false, 0
 	}
-	fragHeight := height / numWorkers
-	// The waitgroup will wait for all workers to finish
-	wg.Add(numWorkers)
-	fragChan := make(chan stubs.Fragment, numWorkers)
+	// EXTENSION: calculate the rows each worker should use, giving faster workers more
+	starts, ends := partitionRows(height, workerWeights(sessionWorkers))
+	// Copy the workers, since the workers slice is changed in place when one disconnects
+	sessionWorkers = append([]*worker{}, sessionWorkers...)
+	// We can release workers now
+	workersMutex.Unlock()
 
+	// Get all the cells required to update each fragment
+	// They are kept so a fragment can be sent again
+	halos := make([]stubs.Halo, numWorkers)
+	wg.Add(numWorkers)
 	for w := 0; w < numWorkers; w++ {
-		thisWorker := workers[w]
-		go func(workerIdx int, worker *worker) {
-			// Get all the cells required to update this fragment
-			halo := makeHalo(workerIdx, fragHeight, numWorkers, height, width, board)
-			// Send the fragment to the worker
-			doWorker(halo, newBoard, threads, worker, failChan, fragChan)
-		}(w, thisWorker)
+		go func(w int) {
+			halos[w] = kernel.MakeHalo(starts[w], ends[w], height, width, board, topology, rule)
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+
+	// Each fragment is sent at most twice, so the channel never blocks, even once we stop listening
+	results := make(chan attempt, 2*numWorkers)
+	// Changes from an earlier attempt at this turn don't count
+	tiles.clearChanged()
+	turn := newSpeculation(sessionWorkers)
+	for w, worker := range sessionWorkers {
+		// Send the fragment to the worker, with the tiles it needs to work out
+		turn.sent(w, worker, ends[w]-starts[w])
+		go doWorker(w, halos[w], threads, rule, tiles.mask(starts[w], ends[w]), worker, results)
 	}
 
-	// We can release workers now
-	workersMutex.Unlock()
-
-	i := 0
-	fail := false
-	for i < numWorkers {
+	// Check for workers that are taking too long every so often
+	check := time.NewTicker(speculationCheck)
+	defer check.Stop()
+	remaining := numWorkers
+	for remaining > 0 {
 		select {
-		case fail = <-failChan:
-			i++
-		case frag := <-fragChan:
+		case result := <-results:
+			if !turn.received(result.frag, result.worker) {
+				// Another worker got there first
+				continue
+			}
+			if result.err != nil {
+				turn.failed(result.worker)
+				// Nothing to do if another worker is still working out this fragment
+				if turn.inProgress(result.frag) {
+					continue
+				}
+				// EXTENSION: keep the fragments we already have, and only send this one to a worker that is still there
+				if other := turn.reassign(result.frag); other != nil {
+					go doWorker(result.frag, halos[result.frag], threads, rule, tiles.mask(starts[result.frag], ends[result.frag]), other, results)
+					continue
+				}
+				// Every worker has failed, so the turn has to be retried once there are more
+				return false, turn.retries
+			}
+			turn.finished(result.frag, result.worker)
 			// Copy the fragment back into the board
-			respCells := frag.BitBoard.ToSlice()
+			frag := result.response.Frag
+			respCells := frag.Board.ToSlice()
 			for row := frag.StartRow; row < frag.EndRow; row++ {
 				copy(newBoard[row], respCells[row-frag.StartRow])
 			}
-			i++
+			tiles.mark(result.response.Changed)
+			remaining--
+		case <-check.C:
+			if !useSpeculation {
+				break
+			}
+			// Send any fragments that are overdue to an idle worker as well
+			for w := range sessionWorkers {
+				if idle := turn.overdue(w); idle != nil {
+					go doWorker(w, halos[w], threads, rule, tiles.mask(starts[w], ends[w]), idle, results)
+				}
+			}
 		}
 	}
 
-	// Check that there have been no fails
-	if fail {
-		// One or more of the workers have hit a problem
-		return false
-	}
+	tiles.advance()
+	return true, turn.retries
+}
 
-	return true
+// maxJumpSize is the most turns HashLife will jump at once
+const maxJumpSize = 1 << 30
+
+// gameLoop stores the state of a session's game while its loop is running
+type gameLoop struct {
+	s        *session
+	board    [][]uint8
+	newBoard [][]uint8
+	turn     int
+	height   int
+	width    int
+	maxTurns int
+	threads  int
+
+	visualUpdates bool
+	rule          stubs.Rule
+	topology      stubs.Topology
+
+	// EXTENSION: turns aren't computed while paused, but keypresses are still handled
+	paused bool
+	// pauseAt is the turn to pause on after running a number of turns, or -1 to keep running
+	pauseAt int
+
+	// EXTENSION: the board's hashes are checked each turn to find when it becomes static or periodic
+	cycles     *util.CycleDetector
+	cycleFound bool
+	// If stopOnCycle is set, the game ends on stopAt once a cycle is found, otherwise stopAt is -1
+	stopOnCycle bool
+	stopAt      int
+
+	// EXTENSION: universe is set if the game is run with HashLife instead of the workers
+	// The board is only brought up to date when it is needed, syncedTurn is the turn it was last updated on
+	universe   *hashlife.Universe
+	syncedTurn int
+	// jumpSize is the most turns HashLife will jump at once, up to maxJumpSize
+	jumpSize int
+
+	// EXTENSION: tiles tracks which parts of the board the workers need to work out
+	tiles *tileMap
+
+	// EXTENSION: strips is set while the workers are keeping strips of the board between turns
+	// The board is only brought up to date when it is needed, like with HashLife
+	strips *stripSet
+
+	// EXTENSION: local is set while we are working out turns ourselves, because there are no workers
+	local bool
+
+	// EXTENSION: speculations counts the fragments sent to a second worker since the last alive cells report
+	speculations int
+}
+
+// Make the state for a session's game loop, starting from a board
+func newGameLoop(s *session, board [][]uint8, startTurn, height, width, maxTurns, threads int, visualUpdates, stopOnCycle bool, rule stubs.Rule, topology stubs.Topology) *gameLoop {
+	g := &gameLoop{
+		s:             s,
+		board:         board,
+		turn:          startTurn,
+		height:        height,
+		width:         width,
+		maxTurns:      maxTurns,
+		threads:       threads,
+		visualUpdates: visualUpdates,
+		rule:          rule,
+		topology:      topology,
+		pauseAt:       -1,
+		cycles:        util.NewCycleDetector(cycleWindow),
+		stopOnCycle:   stopOnCycle,
+		stopAt:        -1,
+		tiles:         newTileMap(height, width),
+	}
+	// Without visual updates we don't need every turn, so HashLife can jump many turns at once
+	// Cycle detection needs every turn, so HashLife isn't used if the game should stop on a cycle
+	if useHashLife && !visualUpdates && !stopOnCycle && hashlife.Supported(height, width, rule, topology) {
+		println("Using HashLife")
+		g.universe = hashlife.New(board, height, width, rule)
+		g.syncedTurn = g.turn
+		g.jumpSize = 1
+	}
+	// Make a new board buffer
+	g.newBoard = make([][]uint8, height)
+	for row := 0; row < height; row++ {
+		g.newBoard[row] = make([]uint8, width)
+	}
+	return g
 }
 
 // This function contains the game loop and sends messages to the controller
 // It will return when the final turn is completed or there is an error
-// When it returns, the controller is disconnected and the server can accept new connections
-func controllerLoop(board [][]bool, startTurn, height, width, maxTurns, threads int, visualUpdates bool) {
+// When it returns, the controller is disconnected and the session can be resumed
+func controllerLoop(s *session, board [][]uint8, startTurn, height, width, maxTurns, threads int, visualUpdates, stopOnCycle bool, rule stubs.Rule, topology stubs.Topology) {
 	// When loop is finished, disconnect controller
 	defer func() {
-		// Lock the controller to be safe
-		controllerMutex.Lock()
-		controller.Close()
-		controller = nil
-		controllerMutex.Unlock()
-		println("Disconnected Controller")
+		// Lock the session to be safe
+		s.Mutex.Lock()
+		if s.Controller != nil {
+			s.Controller.Close()
+			s.Controller = nil
+			println("Disconnected Controller")
+		}
+		// The game has ended, so the session can be resumed
+		s.Info.Running = false
+		s.Info.Paused = false
+		s.publishState(s.Turn, "Finished")
+		s.Mutex.Unlock()
+		// Save where the game ended so it can be resumed, even after a restart
+		writeCheckpoint(s.makeCheckpoint())
+		println("Session", s.ID, "finished")
 	}()
 
-	// This ticker signals us to send turns complete every 2 seconds
-	ticker := time.NewTicker(2 * time.Second)
-
-	turn := startTurn
-	// Make a new board buffer
-	newBoard := make([][]bool, height)
-	for row := 0; row < height; row++ {
-		newBoard[row] = make([]bool, width)
-	}
+	g := newGameLoop(s, board, startTurn, height, width, maxTurns, threads, visualUpdates, stopOnCycle, rule, topology)
 	println("Max turns: ", maxTurns)
+	println("Rule: ", rule.String())
+	println("Topology: ", topology.String())
+	s.History.add(g.turn, board, rule)
+	g.checkCycle()
 
 	// If the controller wants visual updates, send them the first turn
 	if visualUpdates {
-		controller.Call(stubs.ControllerTurnComplete,
-			stubs.BoardStateReport{CompletedTurns: turn, Board: stubs.BitBoardFromSlice(board, height, width)}, &stubs.Empty{})
+		s.callController(stubs.ControllerTurnComplete,
+			stubs.BoardStateReport{CompletedTurns: g.turn, Board: stubs.StateBoardFromSlice(board, height, width, rule.NumStates())})
+	}
+
+	if g.run() {
+		// If the game stopped early on a cycle, the board is the same as it would be on the final turn
+		s.Mutex.Lock()
+		s.Turn = maxTurns
+		s.Mutex.Unlock()
+		println("All turns done, send final turn complete")
+		// Once all turns are done, tell the controller the final turn is complete
+		err := s.callController(stubs.ControllerFinalTurnComplete,
+			stubs.BoardStateReport{
+				CompletedTurns: maxTurns,
+				Board:          stubs.StateBoardFromSlice(board, height, width, rule.NumStates()),
+			})
+		if err != nil {
+			fmt.Println("Error sending final turn complete ", err)
+		}
 	}
+	// End the game
+	return
+}
+
+// Run the game until the final turn is completed
+// Returns false if the game ended early (it was quit or there was an error)
+func (g *gameLoop) run() bool {
+	s := g.s
+	// This ticker signals us to send turns complete every 2 seconds
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	// EXTENSION: this ticker signals us to save a checkpoint of the game
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
+	// Make sure the board is up to date when the game ends, then the workers can forget it
+	defer g.dropStrips()
+	defer g.syncBoard()
+
+	// A closed channel is always ready to receive from, so while the game is running
+	// a turn is computed whenever there is nothing else to do
+	// While paused it is swapped for a nil channel, which is never ready, so we wait for keypresses
+	running := make(chan struct{})
+	close(running)
 
 	// Update the board each turn
-	for turn < maxTurns {
+	for g.turn < g.maxTurns && g.turn != g.stopAt {
+		nextTurn := running
+		if g.paused {
+			nextTurn = nil
+		}
 		select {
 		// Handle incoming keypresses
-		case key := <-keypresses:
+		case key := <-s.Keypresses:
 			println("Received keypress: ", key)
-			quit := handleKeypress(key, turn, board, height, width)
-			if quit {
-				return
+			g.syncBoard()
+			if quit := g.handleKeypress(key); quit {
+				return false
+			}
+		// EXTENSION: run a number of turns, then pause again
+		case turns := <-s.RunTurns:
+			println("Running", turns, "turns")
+			g.pauseAt = g.turn + turns
+			if g.paused {
+				g.setPaused(false)
 			}
 		// Tell the controller how many cells are alive every 2 seconds
 		case <-ticker.C:
 			println("Telling controller number of cells alive")
+			g.syncBoard()
 			// Make the RPC call
-			err := controller.Call(stubs.ControllerReportAliveCells,
-				stubs.AliveCellsReport{CompletedTurns: turn, NumAlive: len(util.GetAliveCells(board))}, &stubs.Empty{})
+			err := s.callController(stubs.ControllerReportAliveCells,
+				stubs.AliveCellsReport{CompletedTurns: g.turn, NumAlive: len(util.GetAliveCells(g.board)), Tiles: g.tiles.report(),
+					Speculations: g.speculations})
+			g.speculations = 0
 			// If there was an error then the client has disconnected, stop the game
 			if err != nil {
 				fmt.Println("Error sending num alive ", err)
-				return
+				return false
 			}
+		// Save a checkpoint so the game can be resumed if the server goes down
+		case <-checkpointTicker.C:
+			g.syncBoard()
+			writeCheckpoint(s.makeCheckpoint())
 		// If there are no other interruptions, handle the game turn
-		default:
-			// Get the next board state (this will send calls to workers)
-			success := updateBoard(board, newBoard, height, width, threads)
-
-			if success {
-				// Copy the board buffer over to the input board
-				for row := 0; row < height; row++ {
-					copy(board[row], newBoard[row])
-				}
-				if visualUpdates {
-					// Tell the controller we have completed a turn
-					// Do this concurrently since we don't need to wait for the controller
-					controller.Call(stubs.ControllerTurnComplete,
-						stubs.BoardStateReport{CompletedTurns: turn, Board: stubs.BitBoardFromSlice(board, height, width)}, &stubs.Empty{})
-				}
-				turn++
-
-				// Save the last board state
-				lastBoardState = board
-				lastTurn = turn
-			} else {
-				if len(workers) == 0 {
-					return
+		case <-nextTurn:
+			if !g.nextTurn() {
+				if noWorkers() {
+					return false
 				}
-				// We hit a problem (e.g. a worker disconnected)
 				// Retry the turn
-				println("Encountered a problem handling turn", turn)
 				println("Retrying this turn")
+				break
+			}
+			// Get the final board from the workers' strips now, since if one is lost we go back and carry on
+			if g.turn == g.maxTurns || g.turn == g.stopAt {
+				g.syncBoard()
+			}
+			// Pause once we have run the turns we were asked to
+			if g.turn == g.pauseAt {
+				g.pauseAt = -1
+				g.setPaused(true)
 			}
 		}
+	}
+	return true
+}
 
+// Compute the next turn with the workers
+// Returns false if there was a problem, in which case the board is unchanged and the turn can be retried
+func (g *gameLoop) nextTurn() bool {
+	s := g.s
+	if g.universe != nil {
+		// EXTENSION: jump as many turns as HashLife can, stopping wherever the game needs to
+		turns := g.maxTurns - g.turn
+		if g.pauseAt >= 0 && g.pauseAt-g.turn < turns {
+			turns = g.pauseAt - g.turn
+		}
+		if g.paused {
+			turns = 1
+		}
+		if turns > g.jumpSize {
+			turns = g.jumpSize
+		}
+		start := time.Now()
+		g.universe.Step(turns)
+		g.turn += turns
+		// Jump further while jumps are quick, but keep them short enough that keypresses are handled quickly
+		// The jump size is capped so doubling it can never overflow
+		if time.Since(start) < 50*time.Millisecond {
+			if g.jumpSize < maxJumpSize {
+				g.jumpSize *= 2
+			}
+		} else if g.jumpSize > 1 {
+			g.jumpSize /= 2
+		}
+		// Stepping while paused should show the new board straight away
+		if g.paused {
+			g.syncBoard()
+		}
+		return true
+	}
+	// EXTENSION: work out turns ourselves while there are no workers
+	local := workLocally()
+	if local != g.local {
+		if local {
+			println("No workers, working out turns locally")
+		} else {
+			println("Workers have connected, handing turns back to them")
+		}
+		g.local = local
+	}
+	if usePersistentStrips && !local {
+		return g.nextStripTurn()
+	}
+	if g.strips != nil {
+		// The last worker has gone, so its strip is lost
+		g.rollback()
+	}
+	// Get the next board state (this will send calls to workers)
+	ok, retries := updateBoard(s, g.board, g.newBoard, g.height, g.width, g.threads, g.rule, g.topology, g.tiles)
+	g.speculations += retries
+	s.Mutex.Lock()
+	s.Info.Speculations += retries
+	s.Mutex.Unlock()
+	if !ok {
+		// We hit a problem (e.g. a worker disconnected)
+		println("Encountered a problem handling turn", g.turn)
+		return false
 	}
 
-	println("All turns done, send final turn complete")
-	// Once all turns are done, tell the controller the final turn is complete
-	err := controller.Call(stubs.ControllerFinalTurnComplete,
-		stubs.BoardStateReport{
-			CompletedTurns: maxTurns,
-			Board:          stubs.BitBoardFromSlice(board, height, width),
-		},
-		&stubs.Empty{})
-	if err != nil {
-		fmt.Println("Error sending final turn complete ", err)
+	// Copy the board buffer over to the input board
+	// Lock the session so HTTP requests don't see a half copied board
+	s.Mutex.Lock()
+	// Send the changed cells to anyone watching the stream
+	s.publishTurn(g.turn+1, g.board, g.newBoard, g.rule)
+	for row := 0; row < g.height; row++ {
+		copy(g.board[row], g.newBoard[row])
 	}
-	// End the game
-	return
+	// Save the last board state
+	s.Board = g.board
+	s.Turn = g.turn + 1
+	s.Info.Tiles = g.tiles.last
+	s.Mutex.Unlock()
+
+	if g.visualUpdates {
+		// Tell the controller we have completed a turn
+		s.callController(stubs.ControllerTurnComplete,
+			stubs.BoardStateReport{
+				CompletedTurns: g.turn,
+				Board:          stubs.StateBoardFromSlice(g.board, g.height, g.width, g.rule.NumStates()),
+				Tiles:          g.tiles.last,
+			})
+	}
+	g.turn++
+	s.History.add(g.turn, g.board, g.rule)
+	g.checkCycle()
+	return true
 }
 
-// EXTENSION: Randomise board function
-// This will randomise a board
-func randomiseBoard(board [][]bool, height, width int) {
-	for row := 0; row < height; row++ {
-		for col := 0; col < width; col++ {
-			// Get a random number from 0.0-1.0
-			r := rand.Float32()
-			// For a smaller number of alive cells, reduce the ratio
-			ratio := float32(0.2)
-			if r < ratio {
-				board[row][col] = true
-			} else {
-				board[row][col] = false
-			}
+// EXTENSION: copy the HashLife universe or the workers' strips into the board, so it can be saved, reported or stepped through
+// Otherwise the workers update the board every turn, so there is nothing to do
+// If a worker's strip is lost, the game goes back to the turn the board is on
+func (g *gameLoop) syncBoard() {
+	if (g.universe == nil && g.strips == nil) || g.syncedTurn == g.turn {
+		return
+	}
+	s := g.s
+	if g.strips != nil {
+		if !g.pullStrips(g.newBoard) {
+			g.rollback()
+			return
+		}
+		s.Mutex.Lock()
+		// Viewers have the board we last had, so send them the cells that changed since then
+		s.publishTurn(g.turn, g.board, g.newBoard, g.rule)
+		for row := 0; row < g.height; row++ {
+			copy(g.board[row], g.newBoard[row])
 		}
+	} else {
+		s.Mutex.Lock()
+		g.universe.Fill(g.board)
+		// Viewers missed the turns in between, so send them the whole board
+		s.publishBoard(g.turn, g.board, g.rule)
 	}
+	s.Board = g.board
+	s.Turn = g.turn
+	s.Mutex.Unlock()
+	s.History.add(g.turn, g.board, g.rule)
+	g.syncedTurn = g.turn
 }
 
-// Cleanly disconnect a worker and remove it from the workers slice
-func disconnectWorker(worker *worker) {
-	// Lock the workers slice to get exclusive access
+// Make the HashLife universe again after the board has been changed
+func (g *gameLoop) reloadUniverse() {
+	if g.universe == nil {
+		return
+	}
+	g.universe = hashlife.New(g.board, g.height, g.width, g.rule)
+	g.syncedTurn = g.turn
+}
+
+// EXTENSION: check whether the board has become static or periodic
+// The controller is told the first time a cycle is found
+func (g *gameLoop) checkCycle() {
+	if g.cycleFound {
+		return
+	}
+	// The workers hash their own strips, so we don't need the whole board
+	var hash uint64
+	if g.strips != nil {
+		hash = g.strips.hash
+	} else {
+		hash = util.HashBoard(g.board)
+	}
+	start, period, found := g.cycles.Add(g.turn, hash)
+	if !found {
+		return
+	}
+	g.cycleFound = true
+	println("Board repeats every", period, "turns from turn", start)
+	g.s.Mutex.Lock()
+	g.s.Info.CycleStart = start
+	g.s.Info.CyclePeriod = period
+	g.s.Mutex.Unlock()
+	g.s.callController(stubs.ControllerCycleDetected,
+		stubs.CycleReport{CompletedTurns: g.turn, StartTurn: start, Period: period})
+
+	if g.stopOnCycle {
+		// The board on the final turn is the same as the board a whole number of periods before it,
+		// so we only need to run the turns left over
+		g.stopAt = g.turn + (g.maxTurns-g.turn)%period
+		println("Stopping early on turn", g.stopAt)
+	}
+}
+
+// Forget any cycle found, because the board has been changed or rewound
+func (g *gameLoop) forgetCycle() {
+	g.cycles.Reset()
+	g.cycleFound = false
+	g.stopAt = -1
+	g.s.Mutex.Lock()
+	g.s.Info.CycleStart = 0
+	g.s.Info.CyclePeriod = 0
+	g.s.Mutex.Unlock()
+}
+
+// Returns true if every worker has disconnected, so turns can't be computed
+// EXTENSION: turns can always be computed if we can work them out ourselves
+func noWorkers() bool {
 	workersMutex.Lock()
 	defer workersMutex.Unlock()
+	return len(workers) == 0 && !useLocalEngine
+}
 
-	// Find the index of the worker
-	for w := 0; w < len(workers); w++ {
-		if workers[w].Address == worker.Address {
-			// Try and close the RPC connection
-			worker.Client.Close()
-			// Rebuild the workers slice without this one in it
-			workers = append(workers[:w], workers[w+1:]...)
-			println("Worker", worker.Address, "disconnected")
-			return
-		}
+// Get the current state of execution, for state change reports
+func (g *gameLoop) state() stubs.State {
+	if g.paused {
+		return stubs.Paused
 	}
-	// We don't contain this worker, do nothing
-	println("We aren't connected to worker", worker.Address)
+	return stubs.Executing
+}
+
+// Pause or resume the game, telling the controller, HTTP API and stream viewers
+func (g *gameLoop) setPaused(paused bool) {
+	if g.paused == paused {
+		return
+	}
+	// The board should be up to date while paused
+	g.syncBoard()
+	previous := g.state()
+	g.paused = paused
+	if paused {
+		println("Pausing execution")
+	} else {
+		println("Resuming execution")
+	}
+	// Tell the controller
+	g.s.callController(stubs.ControllerGameStateChange,
+		stubs.StateChangeReport{Previous: previous, New: g.state(), CompletedTurns: g.turn})
+	g.s.Mutex.Lock()
+	g.s.Info.Paused = paused
+	g.s.Mutex.Unlock()
+	g.s.publishState(g.turn, g.state().String())
 }
 
 // Handle keypress sent from the client
-func handleKeypress(key rune, turn int, board [][]bool, height, width int) bool {
+// Returns true if the game should end
+func (g *gameLoop) handleKeypress(key rune) bool {
+	s := g.s
 	switch key {
 	case 'q':
 		// Quit: send a lastturncomplete message and end the execution
-		controller.Call(stubs.ControllerGameStateChange,
-			stubs.StateChangeReport{Previous: stubs.Executing, New: stubs.Quitting, CompletedTurns: turn}, &stubs.Empty{})
+		s.callController(stubs.ControllerGameStateChange,
+			stubs.StateChangeReport{Previous: g.state(), New: stubs.Quitting, CompletedTurns: g.turn})
 		println("Closing controller")
 		return true
 	case 'p':
-		// Pause: pause execution and wait for another P
-		println("Pausing execution")
-		// Tell the controller we're pausing
-		controller.Call(stubs.ControllerGameStateChange,
-			stubs.StateChangeReport{Previous: stubs.Executing, New: stubs.Paused, CompletedTurns: turn}, &stubs.Empty{})
-		// Wait for another P
-		for <-keypresses != 'p' {
+		// Pause: pause execution until another P
+		// Pressing P also cancels running a number of turns
+		g.pauseAt = -1
+		g.setPaused(!g.paused)
+	case 'n':
+		// EXTENSION: step forward a single turn while paused
+		if !g.paused {
+			break
+		}
+		// Use the history if we have stepped back, so stepping forward retraces the same turns
+		if !g.showTurn(g.turn + 1) {
+			println("Stepping forward one turn")
+			// Keep retrying, so we always stay paused on the next turn
+			for !g.nextTurn() {
+				if noWorkers() {
+					return true
+				}
+			}
+		}
+	case '<', '>':
+		// EXTENSION: while paused, step backwards and forwards through the history
+		// If the game is resumed on an earlier turn, it carries on from there
+		if !g.paused {
+			break
+		}
+		if key == '<' {
+			g.showTurn(g.turn - 1)
+		} else {
+			g.showTurn(g.turn + 1)
 		}
-		// Tell the controller we're resuming
-		controller.Call(stubs.ControllerGameStateChange,
-			stubs.StateChangeReport{Previous: stubs.Paused, New: stubs.Executing, CompletedTurns: turn}, &stubs.Empty{})
-		println("Resuming execution")
 	case 's':
 		// Save: send the board to the controller
+		// Games started over HTTP have no controller, so save the board ourselves
+		if s.Controller == nil {
+			saveBoard(g.board, g.turn, g.height, g.width, g.rule)
+			break
+		}
 		println("Telling controller to save board")
 
-		controller.Call(stubs.ControllerSaveBoard,
-			stubs.BoardStateReport{CompletedTurns: turn, Board: stubs.BitBoardFromSlice(board, height, width)}, &stubs.Empty{})
+		s.callController(stubs.ControllerSaveBoard,
+			stubs.BoardStateReport{CompletedTurns: g.turn, Board: stubs.StateBoardFromSlice(g.board, g.height, g.width, g.rule.NumStates())})
 	case 'k':
 		// Shutdown system: disconnect controller, shutdown workers and ourself
 		println("Controller wants to close everything")
@@ -318,18 +669,22 @@ func handleKeypress(key rune, turn int, board [][]bool, height, width int) bool
 		// Disconnect all workers
 		for w := 0; w < len(workers); w++ {
 			println("Disconnecting worker", w)
-			// Tell the worker to shutdown
-			workers[w].Client.Call(stubs.WorkerShutdown, stubs.Empty{}, &stubs.Empty{})
+			// Tell the worker to shutdown, without waiting forever for one that has hung
+			callWorker(workers[w], stubs.WorkerShutdown, stubs.Empty{}, &stubs.Empty{}, workerTimeout)
 			workers[w].Client.Close()
 		}
 
 		// Disconnect the controller
-		controller.Call(stubs.ControllerFinalTurnComplete,
+		s.callController(stubs.ControllerFinalTurnComplete,
 			stubs.BoardStateReport{
-				CompletedTurns: turn,
-				Board:          stubs.BitBoardFromSlice(board, height, width),
-			},
-			&stubs.Empty{})
+				CompletedTurns: g.turn,
+				Board:          stubs.StateBoardFromSlice(g.board, g.height, g.width, g.rule.NumStates()),
+			})
+
+		// Save every running game, since the server is about to go down
+		for _, running := range runningSessions() {
+			writeCheckpoint(running.makeCheckpoint())
+		}
 
 		// Closing our listener will close our RPC serfver
 		listener.Close()
@@ -338,7 +693,123 @@ func handleKeypress(key rune, turn int, board [][]bool, height, width int) bool
 	case 'r':
 		// EXTENSION: pressing r will randomise the board
 		println("Randomising Board")
-		randomiseBoard(board, height, width)
+		s.Mutex.Lock()
+		randomiseBoard(g.board, g.height, g.width)
+		s.publishBoard(g.turn, g.board, g.rule)
+		s.Mutex.Unlock()
+		s.History.add(g.turn, g.board, g.rule)
+		g.reloadUniverse()
+		g.dropStrips()
+		g.tiles.setAll()
+		g.forgetCycle()
+		g.checkCycle()
 	}
 	return false
 }
+
+// EXTENSION: show the board from another turn in the history, replacing the current board
+// Returns false if the turn isn't in the history
+func (g *gameLoop) showTurn(target int) bool {
+	s := g.s
+	oldBoard, ok := s.History.get(target)
+	if !ok {
+		println("Turn", target, "is not in the history")
+		return false
+	}
+	println("Showing turn", target)
+
+	// Replace the board, letting stream viewers know which cells change
+	s.Mutex.Lock()
+	s.publishTurn(target, g.board, oldBoard, g.rule)
+	for row := 0; row < g.height; row++ {
+		copy(g.board[row], oldBoard[row])
+	}
+	s.Turn = target
+	s.Mutex.Unlock()
+	// Going back means the cycle may not have started yet
+	if target < g.turn {
+		g.forgetCycle()
+	}
+	g.turn = target
+	g.reloadUniverse()
+	g.dropStrips()
+	g.tiles.setAll()
+	g.checkCycle()
+
+	// The controller redraws its window from the new board
+	if g.visualUpdates {
+		s.callController(stubs.ControllerTurnComplete,
+			stubs.BoardStateReport{CompletedTurns: target, Board: stubs.StateBoardFromSlice(g.board, g.height, g.width, g.rule.NumStates())})
+	}
+	return true
+}
+
+// EXTENSION: Randomise board function
+// This will randomise a board
+func randomiseBoard(board [][]uint8, height, width int) {
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			// Get a random number from 0.0-1.0
+			r := rand.Float32()
+			// For a smaller number of alive cells, reduce the ratio
+			ratio := float32(0.2)
+			if r < ratio {
+				board[row][col] = 1
+			} else {
+				board[row][col] = 0
+			}
+		}
+	}
+}
+
+// Cleanly disconnect a worker and remove it from the workers slice
+func disconnectWorker(worker *worker) {
+	// Lock the workers slice to get exclusive access
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+
+	// Find the index of the worker
+	for w := 0; w < len(workers); w++ {
+		if workers[w].Address == worker.Address {
+			// Try and close the RPC connection
+			worker.Client.Close()
+			// Rebuild the workers slice without this one in it
+			workers = append(workers[:w], workers[w+1:]...)
+			println("Worker", worker.Address, "disconnected")
+			return
+		}
+	}
+	// We don't contain this worker, do nothing
+	println("We aren't connected to worker", worker.Address)
+}
+
+// Make an RPC call to the session's controller, ignoring the reply
+// Games started over HTTP have no controller, so there is nothing to call and no error
+func (s *session) callController(method string, args interface{}) error {
+	if s.Controller == nil {
+		return nil
+	}
+	return s.Controller.Call(method, args, &stubs.Empty{})
+}
+
+// EXTENSION: save the board on the server as an RLE pattern in the out directory
+// This is used when there is no controller to send the board to
+func saveBoard(board [][]uint8, turn int, height, width int, rule stubs.Rule) {
+	filename := filepath.Join("out", strconv.Itoa(width)+"x"+strconv.Itoa(height)+"x"+strconv.Itoa(turn)+".rle")
+	println("Saving board to", filename)
+	err := os.MkdirAll("out", os.ModePerm)
+	if err != nil {
+		println("Error saving board:", err.Error())
+		return
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		println("Error saving board:", err.Error())
+		return
+	}
+	defer file.Close()
+	err = pattern.WriteRLE(file, board, rule.String(), rule.NumStates())
+	if err != nil {
+		println("Error saving board:", err.Error())
+	}
+}
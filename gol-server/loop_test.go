@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// fakeWorker works out fragments like a real worker, but can be made to die part way through a turn
+type fakeWorker struct {
+	listener net.Listener
+	// die makes the worker close its connections when it is sent a fragment, like a worker being killed
+	die bool
+	// hang makes the worker wait until it is closed before working anything out, like a worker that has hung
+	hang chan struct{}
+	// mutex guards the fields below
+	mutex sync.Mutex
+	conns []net.Conn
+	turns int
+}
+
+func init() {
+	// Give up on fake workers that have hung quickly, so the tests don't take long
+	workerTimeout = 500 * time.Millisecond
+}
+
+// DoTurn works out the next turn of a fragment, one cell at a time
+func (f *fakeWorker) DoTurn(req stubs.DoTurnRequest, res *stubs.DoTurnResponse) error {
+	if f.die {
+		// Give the other workers time to send their fragments back first, so the turn is part done
+		time.Sleep(50 * time.Millisecond)
+		f.kill()
+		return errors.New("killed")
+	}
+	if f.hang != nil {
+		<-f.hang
+	}
+	f.mutex.Lock()
+	f.turns++
+	f.mutex.Unlock()
+
+	halo := req.Halo
+	height, width := halo.EndPtr-halo.StartPtr, halo.Board.RowLength
+	board := halo.Board.Decode()
+	newBoard := make([][]uint8, height)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	kernel.UpdateRegion(0, height, halo, newBoard, width, board, nil, req.Rule, req.Active, &wg)
+	res.Frag = stubs.Fragment{
+		StartRow: halo.StartPtr,
+		EndRow:   halo.EndPtr,
+		Board:    stubs.StateBoardFromSlice(newBoard, height, width, req.Rule.NumStates()),
+	}
+	res.Changed = kernel.ChangedCells(halo, newBoard, board)
+	return nil
+}
+
+// Stop listening and close every connection
+func (f *fakeWorker) kill() {
+	f.listener.Close()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+// Get how many fragments the worker has worked out
+func (f *fakeWorker) getTurns() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.turns
+}
+
+// Start a fake worker, and connect to it as if it had connected to us
+func startFakeWorker(t *testing.T, die bool) (*fakeWorker, *worker) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeWorker{listener: listener, die: die}
+	server := rpc.NewServer()
+	server.RegisterName("Worker", f)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mutex.Lock()
+			f.conns = append(f.conns, conn)
+			f.mutex.Unlock()
+			go server.ServeConn(conn)
+		}
+	}()
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, &worker{Client: client, Address: listener.Addr().String(), Cores: 1}
+}
+
+// Make a random board
+func randomBoard(height, width int) [][]uint8 {
+	board := make([][]uint8, height)
+	for row := range board {
+		board[row] = make([]uint8, width)
+		for col := range board[row] {
+			if rand.Intn(3) == 0 {
+				board[row][col] = 1
+			}
+		}
+	}
+	return board
+}
+
+// Make an empty board
+func emptyBoard(height, width int) [][]uint8 {
+	board := make([][]uint8, height)
+	for row := range board {
+		board[row] = make([]uint8, width)
+	}
+	return board
+}
+
+// Connect fake workers, run a turn with them, and disconnect them again
+// Returns whether the turn succeeded, the new board and the workers still connected after the turn
+func runFakeTurn(t *testing.T, fakes []*fakeWorker, connected []*worker, board [][]uint8) (bool, [][]uint8, []*worker) {
+	workersMutex.Lock()
+	workers = connected
+	workersMutex.Unlock()
+	defer func() {
+		for _, f := range fakes {
+			f.kill()
+		}
+		workersMutex.Lock()
+		workers = make([]*worker, 0)
+		workersMutex.Unlock()
+	}()
+
+	height, width := len(board), len(board[0])
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	newBoard := emptyBoard(height, width)
+	ok, _ := updateBoard(&session{ID: "test"}, board, newBoard, height, width, 2, rule, stubs.Torus, newTileMap(height, width))
+	workersMutex.Lock()
+	remaining := append([]*worker{}, workers...)
+	workersMutex.Unlock()
+	return ok, newBoard, remaining
+}
+
+// TestPartialRetry kills a worker part way through a turn, and checks only its fragment is worked out again
+func TestPartialRetry(t *testing.T) {
+	height, width := 96, 80
+	board := randomBoard(height, width)
+
+	// Work out the turn with workers that don't fail
+	fakes, connected := []*fakeWorker{}, []*worker{}
+	for i := 0; i < 3; i++ {
+		f, w := startFakeWorker(t, false)
+		fakes, connected = append(fakes, f), append(connected, w)
+	}
+	ok, expected, _ := runFakeTurn(t, fakes, connected, board)
+	if !ok {
+		t.Fatal("turn failed without any workers failing")
+	}
+
+	// Work out the turn again, with the middle worker killed part way through
+	fakes, connected = []*fakeWorker{}, []*worker{}
+	for i := 0; i < 3; i++ {
+		f, w := startFakeWorker(t, i == 1)
+		fakes, connected = append(fakes, f), append(connected, w)
+	}
+	lost := connected[1]
+	ok, got, remaining := runFakeTurn(t, fakes, connected, board)
+	if !ok {
+		t.Fatal("turn failed, rather than the lost worker's fragment being sent to another worker")
+	}
+	for row := range expected {
+		for col := range expected[row] {
+			if got[row][col] != expected[row][col] {
+				t.Fatalf("cell (%v, %v) is %v, expected %v", col, row, got[row][col], expected[row][col])
+			}
+		}
+	}
+	// The fragments that came back shouldn't have been worked out again
+	turns := fakes[0].getTurns() + fakes[2].getTurns()
+	if turns != 3 {
+		t.Errorf("the surviving workers worked out %v fragments, expected 3", turns)
+	}
+	for _, w := range remaining {
+		if w == lost {
+			t.Errorf("the lost worker is still connected")
+		}
+	}
+	if len(remaining) != 2 {
+		t.Errorf("%v workers are still connected, expected 2", len(remaining))
+	}
+}
+
+// TestAllWorkersFail checks a turn fails if there are no workers left to send fragments to
+func TestAllWorkersFail(t *testing.T) {
+	height, width := 32, 32
+	fakes, connected := []*fakeWorker{}, []*worker{}
+	for i := 0; i < 2; i++ {
+		f, w := startFakeWorker(t, true)
+		fakes, connected = append(fakes, f), append(connected, w)
+	}
+	if ok, _, _ := runFakeTurn(t, fakes, connected, randomBoard(height, width)); ok {
+		t.Fatal("turn succeeded without any workers")
+	}
+}
+
+// TestWorkerDeadline checks a worker that hangs is treated as lost once it is past its deadline,
+// and its fragment is sent to another worker
+func TestWorkerDeadline(t *testing.T) {
+	height, width := 96, 80
+	board := randomBoard(height, width)
+	expected := emptyBoard(height, width)
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	updateBoardLocally(board, expected, height, width, rule, stubs.Torus, newTileMap(height, width))
+
+	fakes, connected := []*fakeWorker{}, []*worker{}
+	for i := 0; i < 3; i++ {
+		f, w := startFakeWorker(t, false)
+		fakes, connected = append(fakes, f), append(connected, w)
+	}
+	fakes[1].hang = make(chan struct{})
+	defer close(fakes[1].hang)
+	hung := connected[1]
+	start := time.Now()
+	ok, got, remaining := runFakeTurn(t, fakes, connected, board)
+	if !ok {
+		t.Fatal("turn failed, rather than the hung worker's fragment being sent to another worker")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("turn took %v, the hung worker should have been given up on after %v", took, workerTimeout)
+	}
+	for row := range expected {
+		for col := range expected[row] {
+			if got[row][col] != expected[row][col] {
+				t.Fatalf("cell (%v, %v) is %v, expected %v", col, row, got[row][col], expected[row][col])
+			}
+		}
+	}
+	for _, w := range remaining {
+		if w == hung {
+			t.Errorf("the hung worker is still connected")
+		}
+	}
+}
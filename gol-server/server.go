@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"net"
+	"net/http"
 	"net/rpc"
 	"sync"
+	"time"
 
 	"uk.ac.bris.cs/gameoflife/stubs"
 )
@@ -14,24 +16,26 @@ import (
 type worker struct {
 	Client  *rpc.Client
 	Address string
+	// Cores is how many CPU cores the worker says it has
+	Cores int
+
+	// EXTENSION: speed is how many rows a second the worker has been working out, or 0 until it is measured
+	// It is shared by every session the worker works for, so it is guarded by speedMutex
+	speed      float64
+	speedMutex sync.Mutex
 }
 
 // Global variables
 var (
-	controller      *rpc.Client
-	controllerMutex sync.Mutex
-	lastBoardState  [][]bool
-	lastTurn        int
-
 	workers      []*worker
 	workersMutex sync.Mutex
-	keypresses   chan rune
 	listener     net.Listener
+	// useHashLife lets games without visual updates run with HashLife instead of the workers
+	useHashLife bool
 )
 
 // Setup variables on program start
 func init() {
-	keypresses = make(chan rune, 10)
 	workers = make([]*worker, 0)
 }
 
@@ -45,29 +49,40 @@ func init() {
 type Server struct{}
 
 // StartGame is called by the controller when it wants to connect and start a game
+// EXTENSION: each game runs in its own session, so many controllers can connect at once
 func (s *Server) StartGame(req stubs.StartGameRequest, res *stubs.ServerResponse) (err error) {
-	// Lock the controller until we have finished
-	controllerMutex.Lock()
-	defer controllerMutex.Unlock()
 	println("Received request to start a game")
-	// If we already have a controller respond false
-	if controller != nil {
-		println("We already have a controller")
-		res.Message = "Server already has a controller"
-		res.Success = false
-		return
-	}
 
-	// Controllers can't connect if we have no workers
-	if len(workers) == 0 {
+	// Controllers can't connect if we have no workers (unless we can work out turns ourselves)
+	workersMutex.Lock()
+	numWorkers := len(workers)
+	workersMutex.Unlock()
+	if numWorkers == 0 && !useLocalEngine {
 		println("We have no workers available")
 		res.Message = "Server has no workers"
 		res.Success = false
 		return
 	}
 
+	// Make sure the rule is valid before we start anything
+	rule, err := stubs.ParseRule(req.Rule)
+	if err != nil {
+		println("Invalid rule:", req.Rule)
+		res.Message = "Invalid rule \"" + req.Rule + "\": " + err.Error()
+		res.Success = false
+		return nil
+	}
+	topology, err := stubs.ParseTopology(req.Topology)
+	if err != nil {
+		println("Invalid topology:", req.Topology)
+		res.Message = "Invalid topology \"" + req.Topology + "\": " + err.Error()
+		res.Success = false
+		return nil
+	}
+
 	// Connect to the new controller's RPC server
-	newController, err := rpc.Dial("tcp", req.ControllerAddress)
+	// EXTENSION: use the connection the controller opened for us if it has one
+	newController, err := dialBack(req.Callback, req.ControllerAddress)
 	if err != nil {
 		println("Error connecting to controller: ", err.Error())
 		res.Message = "Failed to connect to controller"
@@ -75,71 +90,106 @@ func (s *Server) StartGame(req stubs.StartGameRequest, res *stubs.ServerResponse
 		return err
 	}
 
-	var newBoard [][]bool
+	var game *session
+	var newBoard [][]uint8
 	startTurn := 0
+	maxTurns := req.MaxTurns
 	if req.StartNew {
 		println("Starting a new game!")
+		game = newSession()
 		newBoard = req.Board.ToSlice()
 	} else {
 		println("Client resuming previous game")
 		// The client wants to resume
-		if lastBoardState == nil {
-			// We need a previous board to resume from
-			println("Error resuming board: no previous board")
-			res.Message = "Error resuming: no previous board"
-			res.Success = false
-			return
-		}
-
-		// Continue with the previous
-		// Make sure height and width match
-		if req.Height != len(lastBoardState) || req.Width != len(lastBoardState[0]) {
-			println("Error resuming board: controller has the wrong height and width")
-			res.Message = "Error resuming: controller had the wrong height and width"
+		game, newBoard, startTurn, err = resumeSession(req.SessionID, req.Height, req.Width)
+		if err != nil {
+			println("Error resuming board:", err.Error())
+			newController.Close()
+			res.Message = "Error resuming: " + err.Error()
 			res.Success = false
-			return
-		}
-		// Copy the last board state
-		newBoard = make([][]bool, req.Height)
-		for row := 0; row < req.Height; row++ {
-			newBoard[row] = make([]bool, req.Width)
-			copy(newBoard[row], lastBoardState[row])
+			return nil
 		}
-		println("Resuming at turn ", lastTurn)
-		startTurn = lastTurn
+		// The controller always sends its own settings, so carry on with the ones the game was played with
+		game.Mutex.Lock()
+		rule, topology, maxTurns = game.Info.Rule, game.Info.Topology, game.Info.MaxTurns
+		game.Mutex.Unlock()
 	}
 
 	// If successful store the controller reference
-	controller = newController
-	println("Controller connected")
+	println("Controller connected to session", game.ID)
 	res.Success = true
 	res.Message = "Connected!"
+	res.SessionID = game.ID
 
 	// Run the controller loop goroutine
-	go controllerLoop(newBoard, startTurn, req.Height, req.Width, req.MaxTurns, req.Threads, req.VisualUpdates)
+	startSession(game, newController, newBoard, startTurn, req.Height, req.Width, maxTurns, req.Threads, req.VisualUpdates, req.StopOnCycle, rule, topology)
 	return
 }
 
 // RegisterKeypress is called by controller when a key is pressed on their SDL window
 func (s *Server) RegisterKeypress(req stubs.KeypressRequest, res *stubs.ServerResponse) (err error) {
 	println("Received keypress request")
-	// Send the keypress down down the keypresses channel
-	keypresses <- req.Key
+	// Find the controller's session
+	game := getSession(req.SessionID)
+	if game == nil || !game.IsRunning() {
+		res.Message = "No game is running in this session"
+		res.Success = false
+		return
+	}
+	// Send the keypress down down the session's keypresses channel
+	// The game may have ended since we checked, and then nothing will take the keypress
+	select {
+	case game.Keypresses <- req.Key:
+	case <-time.After(sendWait):
+		res.Message = "Game is no longer running"
+		res.Success = false
+		return
+	}
+	res.Success = true
+	return
+}
+
+// RunTurns is called to run a number of turns and then pause again
+// EXTENSION: if the game is paused it is resumed until the turns are done
+func (s *Server) RunTurns(req stubs.RunTurnsRequest, res *stubs.ServerResponse) (err error) {
+	println("Received request to run", req.Turns, "turns")
+	if req.Turns <= 0 {
+		res.Message = "Number of turns must be positive"
+		res.Success = false
+		return
+	}
+	// Find the session to run the turns in
+	game := getSession(req.SessionID)
+	if game == nil || !game.IsRunning() {
+		res.Message = "No game is running in this session"
+		res.Success = false
+		return
+	}
+	select {
+	case game.RunTurns <- req.Turns:
+	case <-time.After(sendWait):
+		res.Message = "Game is no longer running"
+		res.Success = false
+		return
+	}
+	res.Success = true
+	res.SessionID = game.ID
 	return
 }
 
 // ConnectWorker is called by workers who want to connect
 func (s *Server) ConnectWorker(req stubs.WorkerConnectRequest, res *stubs.ServerResponse) (err error) {
-	println("Worker at", req.WorkerAddress, "wants to connect")
+	println("Worker at", req.WorkerAddress, "with", req.Cores, "cores wants to connect")
 	// Try to connect to the worker's RPC
-	workerClient, err := rpc.Dial("tcp", req.WorkerAddress)
+	// EXTENSION: use the connection the worker opened for us if it has one
+	workerClient, err := dialBack(req.Callback, req.WorkerAddress)
 	if err != nil {
 		println("Error connecting to worker: ", err.Error())
 		return err
 	}
 
 	// If successful add the worker to the workers slice
-	newWorker := worker{Address: req.WorkerAddress, Client: workerClient}
+	newWorker := worker{Address: req.WorkerAddress, Client: workerClient, Cores: req.Cores}
 	foundExisting := false
 
 	// Lock the slice to get exclusive access
@@ -182,10 +232,43 @@ func main() {
 	// Read in the network port we should listen on, from the commandline argument.
 	// Default to port 8030
 	portPtr := flag.String("p", "8020", "port to listen on")
+	// EXTENSION: address for the HTTP API, which is off unless given
+	httpPtr := flag.String("http", "", "address to serve the HTTP API on (e.g. :8080), off if empty")
+	// EXTENSION: where and how often to save checkpoints of running games
+	flag.StringVar(&checkpointDir, "checkpoints", "checkpoints", "directory to save checkpoints in, off if empty")
+	flag.DurationVar(&checkpointInterval, "checkpoint-every", time.Minute, "how often to save a checkpoint of each game")
+	// EXTENSION: how many turns paused games can be stepped back through
+	flag.IntVar(&historyLength, "history", 100, "number of turns kept in each game's history, off if 0")
+	// EXTENSION: the longest cycle that can be detected
+	flag.IntVar(&cycleWindow, "cycle-window", 1024, "longest cycle period to detect, off if 0")
+	// EXTENSION: whether games without visual updates can be run with HashLife
+	// It is off by default, since games then finish too quickly to be reported on every 2 seconds
+	flag.BoolVar(&useHashLife, "hashlife", false, "run games without visual updates with HashLife when the board allows it")
+	// EXTENSION: whether workers keep their strips of the board between turns
+	flag.BoolVar(&usePersistentStrips, "strips", true, "let workers keep their strips of the board between turns, only sending the rows between them")
+	flag.BoolVar(&usePeers, "peers", true, "let workers with strips send the rows between them straight to each other")
+	// EXTENSION: whether fragments that are taking too long are sent to a second worker
+	flag.BoolVar(&useSpeculation, "speculate", true, "send fragments that are taking too long to an idle worker as well, using whichever comes back first")
+	// EXTENSION: how long a worker has to answer before it is treated as lost
+	flag.DurationVar(&workerTimeout, "timeout", workerTimeout, "least time a worker has to answer a call before it is treated as lost")
+	// EXTENSION: whether we work out turns ourselves while there are no workers
+	flag.BoolVar(&useLocalEngine, "local", true, "work out turns on the server while no workers are connected")
 	flag.Parse()
 	println("Started server")
 	println("Our RPC port:", *portPtr)
 
+	// Load the checkpoints from before we were last stopped, so their games can be resumed
+	loadCheckpoints()
+
+	// Start the HTTP API alongside RPC
+	if *httpPtr != "" {
+		println("Our HTTP address:", *httpPtr)
+		go func() {
+			err := http.ListenAndServe(*httpPtr, httpHandler())
+			println("HTTP server closed:", err.Error())
+		}()
+	}
+
 	// Register our RPC server
 	rpc.Register(&Server{})
 
@@ -194,7 +277,8 @@ func main() {
 	listener = ln
 
 	// This will block until the listener is closed
-	rpc.Accept(listener)
+	// EXTENSION: connections opened for us to call controllers and workers back on are kept for them
+	serveConnections(listener)
 
 	println("Server closed")
 }
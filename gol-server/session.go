@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"net/rpc"
+	"sort"
+	"strconv"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: sessions
+// Each game runs in its own session, so several games can share the server and its workers
+// Sessions are kept after their game ends so they can be resumed
+
+/////////
+
+// gameInfo stores details about the game being run in a session
+type gameInfo struct {
+	Running  bool
+	Paused   bool
+	MaxTurns int
+	Height   int
+	Width    int
+	Rule     stubs.Rule
+	Topology stubs.Topology
+	// CycleStart and CyclePeriod are set once the board is found to be static or periodic
+	CycleStart  int
+	CyclePeriod int
+	// Tiles counts the tiles worked out and skipped on the last turn
+	Tiles stubs.TileStats
+	// Speculations counts the fragments sent to a second worker, because the first was too slow
+	Speculations int
+}
+
+// session stores everything about one game
+type session struct {
+	ID string
+	// Order counts up as sessions are started, so the workers can be shared out the same way each turn
+	Order int
+	// Controller is nil for games started over HTTP
+	Controller *rpc.Client
+	Keypresses chan rune
+	// RunTurns receives the number of turns to run before pausing again
+	RunTurns chan int
+	// History is the game's recent boards, it is only used by the game loop
+	History *history
+
+	// Mutex guards the fields below
+	// It is also held while the board is updated so other goroutines never see half a turn
+	Mutex sync.Mutex
+	Info  gameInfo
+	Board [][]uint8
+	Turn  int
+
+	// Viewers watching the stream of this session
+	Subscribers      map[*subscriber]bool
+	SubscribersMutex sync.Mutex
+}
+
+// maxFinishedSessions is how many finished sessions are kept for resuming
+// Older ones are removed to save memory
+const maxFinishedSessions = 8
+
+var (
+	sessions      = make(map[string]*session)
+	sessionsMutex sync.Mutex
+	nextSession   = 1
+)
+
+// Make a new session and add it to the sessions map
+// The session does not run until startSession is called
+func newSession() *session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	s := &session{
+		ID:          strconv.Itoa(nextSession),
+		Order:       nextSession,
+		Keypresses:  make(chan rune, 10),
+		RunTurns:    make(chan int, 10),
+		Subscribers: make(map[*subscriber]bool),
+	}
+	nextSession++
+	sessions[s.ID] = s
+	pruneSessions()
+	return s
+}
+
+// Remove the oldest finished sessions once there are too many
+// The caller must hold the sessions mutex
+func pruneSessions() {
+	finished := make([]*session, 0)
+	for _, s := range sessions {
+		s.Mutex.Lock()
+		// Sessions without a board haven't been started yet
+		if !s.Info.Running && s.Board != nil {
+			finished = append(finished, s)
+		}
+		s.Mutex.Unlock()
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Order < finished[j].Order })
+	for len(finished) > maxFinishedSessions {
+		println("Removing finished session", finished[0].ID)
+		delete(sessions, finished[0].ID)
+		removeCheckpoint(finished[0].ID)
+		finished = finished[1:]
+	}
+}
+
+// Get a session by its ID
+// If the ID is empty, the most recently started session is returned
+// Returns nil if there is no such session
+func getSession(id string) *session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if id != "" {
+		return sessions[id]
+	}
+	var latest *session
+	for _, s := range sessions {
+		if latest == nil || s.Order > latest.Order {
+			latest = s
+		}
+	}
+	return latest
+}
+
+// Get the session a game should be resumed from
+// If the ID is empty, the most recently started session that has a board and isn't running is returned
+// Returns nil if there is no such session
+func getResumableSession(id string) *session {
+	if id != "" {
+		return getSession(id)
+	}
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	var latest *session
+	for _, s := range sessions {
+		s.Mutex.Lock()
+		resumable := !s.Info.Running && s.Board != nil
+		s.Mutex.Unlock()
+		if resumable && (latest == nil || s.Order > latest.Order) {
+			latest = s
+		}
+	}
+	return latest
+}
+
+// Returns every session, oldest first
+func allSessions() []*session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	list := make([]*session, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Order < list[j].Order })
+	return list
+}
+
+// Returns the sessions that are running a game, oldest first
+func runningSessions() []*session {
+	running := make([]*session, 0)
+	for _, s := range allSessions() {
+		if s.IsRunning() {
+			running = append(running, s)
+		}
+	}
+	return running
+}
+
+// IsRunning returns true if the session's game loop is running
+func (s *session) IsRunning() bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return s.Info.Running
+}
+
+// Find the session to resume and copy its board
+// If the ID is empty the most recent session that isn't running is resumed
+// The height and width must match the session's board
+func resumeSession(id string, height, width int) (*session, [][]uint8, int, error) {
+	s := getResumableSession(id)
+	// We need a previous board to resume from
+	if s == nil {
+		return nil, nil, 0, errors.New("no previous board")
+	}
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if s.Board == nil {
+		return nil, nil, 0, errors.New("no previous board")
+	}
+	if s.Info.Running {
+		return nil, nil, 0, errors.New("session " + s.ID + " is still running")
+	}
+	// Make sure height and width match
+	if height != len(s.Board) || width != len(s.Board[0]) {
+		return nil, nil, 0, errors.New("wrong height and width for the previous board")
+	}
+	// Claim the session so nobody else can resume it at the same time
+	s.Info.Running = true
+	// Copy the last board state
+	board := make([][]uint8, height)
+	for row := 0; row < height; row++ {
+		board[row] = make([]uint8, width)
+		copy(board[row], s.Board[row])
+	}
+	println("Resuming session", s.ID, "at turn", s.Turn)
+	return s, board, s.Turn, nil
+}
+
+// Store the session's new game and run its game loop
+// The controller may be nil if the game was started over HTTP
+func startSession(s *session, controller *rpc.Client, board [][]uint8, startTurn, height, width, maxTurns, threads int, visualUpdates, stopOnCycle bool, rule stubs.Rule, topology stubs.Topology) {
+	s.Mutex.Lock()
+	s.Controller = controller
+	s.Info = gameInfo{
+		Running:  true,
+		MaxTurns: maxTurns,
+		Height:   height,
+		Width:    width,
+		Rule:     rule,
+		Topology: topology,
+	}
+	s.Board = board
+	s.Turn = startTurn
+	s.History = newHistory(historyLength)
+	// Empty out any keypresses left over from a previous game in this session
+	for len(s.Keypresses) > 0 {
+		<-s.Keypresses
+	}
+	for len(s.RunTurns) > 0 {
+		<-s.RunTurns
+	}
+	// Viewers watching the stream need the new board
+	s.publishBoard(startTurn, board, rule)
+	s.Mutex.Unlock()
+	// Save the starting board straight away, so even a crash on the first turn can be resumed
+	writeCheckpoint(s.makeCheckpoint())
+
+	println("Starting session", s.ID)
+	go controllerLoop(s, board, startTurn, height, width, maxTurns, threads, visualUpdates, stopOnCycle, rule, topology)
+}
+
+// Get the workers a session should send its turn to
+// The workers are shared out between the running sessions, so their turns run side by side
+// If there are more sessions than workers, some sessions share a single worker
+// The caller must hold the workers mutex
+func sessionWorkers(s *session) []*worker {
+	running := runningSessions()
+	// Find where this session is in the running sessions
+	index := 0
+	for i := range running {
+		if running[i] == s {
+			index = i
+		}
+	}
+	numSessions := len(running)
+	if numSessions <= 1 || len(workers) == 0 {
+		return workers
+	}
+	if len(workers) < numSessions {
+		return []*worker{workers[index%len(workers)]}
+	}
+	share := make([]*worker, 0)
+	for w := index; w < len(workers); w += numSessions {
+		share = append(share, workers[w])
+	}
+	return share
+}
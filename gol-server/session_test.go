@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// TestResumeLatestFinished checks resuming without a session ID skips sessions that are still running
+func TestResumeLatestFinished(t *testing.T) {
+	finished, running := newSession(), newSession()
+	defer func() {
+		sessionsMutex.Lock()
+		delete(sessions, finished.ID)
+		delete(sessions, running.ID)
+		sessionsMutex.Unlock()
+	}()
+	finished.Board = emptyBoard(16, 16)
+	running.Board = emptyBoard(16, 16)
+	running.Info.Running = true
+
+	s, _, _, err := resumeSession("", 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != finished {
+		t.Errorf("resumed session %v, expected the finished session %v", s.ID, finished.ID)
+	}
+	// Resuming claims the session, so there is nothing left to resume
+	if _, _, _, err := resumeSession("", 16, 16); err == nil {
+		t.Error("resumed a session that is running")
+	}
+}
+
+// fakeController accepts every call the server makes, and passes on the final turn
+type fakeController struct {
+	final chan stubs.BoardStateReport
+}
+
+func (c *fakeController) GameStateChange(req stubs.StateChangeReport, res *stubs.Empty) error {
+	return nil
+}
+
+func (c *fakeController) FinalTurnComplete(req stubs.BoardStateReport, res *stubs.Empty) error {
+	c.final <- req
+	return nil
+}
+
+func (c *fakeController) TurnComplete(req stubs.BoardStateReport, res *stubs.Empty) error {
+	return nil
+}
+
+func (c *fakeController) SaveBoard(req stubs.BoardStateReport, res *stubs.Empty) error {
+	return nil
+}
+
+func (c *fakeController) ReportAliveCells(req stubs.AliveCellsReport, res *stubs.Empty) error {
+	return nil
+}
+
+func (c *fakeController) CycleDetected(req stubs.CycleReport, res *stubs.Empty) error {
+	return nil
+}
+
+// Start a fake controller, returning it and the address it is listening on
+func startFakeController(t *testing.T) (*fakeController, string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeController{final: make(chan stubs.BoardStateReport, 1)}
+	server := rpc.NewServer()
+	server.RegisterName("Controller", c)
+	go server.Accept(listener)
+	return c, listener.Addr().String(), func() { listener.Close() }
+}
+
+// Wait for a session's checkpoint to be saved on a turn, giving up after a few seconds
+// Returns with the checkpoint mutex held, so nothing else is saved until it is unlocked
+func waitForCheckpoint(t *testing.T, id string, turn int) {
+	for tries := 0; ; tries++ {
+		checkpointMutex.Lock()
+		var c checkpoint
+		file, err := os.Open(checkpointPath(id))
+		if err == nil {
+			err = gob.NewDecoder(file).Decode(&c)
+			file.Close()
+		}
+		if (err == nil && c.Turn == turn) || tries == 100 {
+			return
+		}
+		checkpointMutex.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestResumeCheckpointRule resumes a Generations checkpoint over RPC with a controller that sends the default rule,
+// and checks the game carries on with the rule, topology and turns it was saved with
+func TestResumeCheckpointRule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpointDir, checkpointInterval, useLocalEngine = dir, time.Minute, true
+	defer func() {
+		// The game saves a checkpoint once it has finished, so wait for it before the checkpoints are turned off
+		waitForCheckpoint(t, "resume", 8)
+		checkpointDir, checkpointInterval, useLocalEngine = "", 0, false
+		checkpointMutex.Unlock()
+		os.RemoveAll(dir)
+		sessionsMutex.Lock()
+		delete(sessions, "resume")
+		sessionsMutex.Unlock()
+	}()
+
+	height, width := 32, 32
+	rule, _ := stubs.ParseRule("345/2/4")
+	board := randomBoard(height, width)
+	for row := range board {
+		for col := range board[row] {
+			// Give some of the cells dying states
+			if (row+col)%5 == 0 {
+				board[row][col] = 3
+			}
+		}
+	}
+	writeCheckpoint(checkpoint{
+		SessionID: "resume",
+		Order:     1000,
+		Turn:      3,
+		MaxTurns:  8,
+		Height:    height,
+		Width:     width,
+		Rule:      rule.String(),
+		Topology:  stubs.Plane.String(),
+		Board:     stubs.StateBoardFromSlice(board, height, width, rule.NumStates()),
+	})
+	loadCheckpoints()
+
+	// Work out what the board should be on the final turn
+	expected := emptyBoard(height, width)
+	tiles := newTileMap(height, width)
+	for turn := 3; turn < 8; turn++ {
+		updateBoardLocally(board, expected, height, width, rule, stubs.Plane, tiles)
+		for row := range board {
+			copy(board[row], expected[row])
+		}
+	}
+
+	controller, address, stop := startFakeController(t)
+	defer stop()
+	res := new(stubs.ServerResponse)
+	err = (&Server{}).StartGame(stubs.StartGameRequest{
+		ControllerAddress: address,
+		Height:            height,
+		Width:             width,
+		MaxTurns:          1000,
+		Threads:           1,
+		Rule:              stubs.DefaultRule,
+		Topology:          stubs.Torus.String(),
+		StartNew:          false,
+		SessionID:         "resume",
+	}, res)
+	if err != nil || !res.Success {
+		t.Fatal("couldn't resume:", err, res.Message)
+	}
+
+	select {
+	case final := <-controller.final:
+		if final.CompletedTurns != 8 {
+			t.Errorf("game finished on turn %v, expected the checkpoint's 8", final.CompletedTurns)
+		}
+		got := final.Board.ToSlice()
+		for row := range expected {
+			for col := range expected[row] {
+				if got[row][col] != expected[row][col] {
+					t.Fatalf("cell (%v, %v) is %v, expected %v", col, row, got[row][col], expected[row][col])
+				}
+			}
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("game didn't finish")
+	}
+}
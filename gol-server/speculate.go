@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/rpc"
+	"time"
+)
+
+/////////
+
+// EXTENSION: speculative retries
+// A worker that is slow or has hung would hold up the whole turn until its connection fails
+// Each fragment has a deadline worked out from how fast its worker has been, and once a fragment is past it,
+// it is also sent to a worker that has finished its own fragment, and whichever comes back first is used
+// EXTENSION: partial retries
+// If a worker fails, the fragments that have already come back are kept, and only its fragment is sent to another worker
+// EXTENSION: deadlines
+// Every call to a worker has a deadline, well past when it should have answered, and a worker that misses it is
+// treated as lost, so a worker that has hung can't hold up a game forever
+
+/////////
+
+var (
+	// useSpeculation lets fragments that are taking too long be sent to a second worker
+	useSpeculation bool
+	// workerTimeout is the least time a worker is given to answer a call before it is treated as lost
+	// It is well under the 5 seconds controllers wait for an alive cells report, since none are sent during a turn
+	workerTimeout = 2 * time.Second
+)
+
+const (
+	// speculationCheck is how often we look for fragments that are past their deadline
+	speculationCheck = 10 * time.Millisecond
+	// speculationSlack is how many times longer than expected a fragment can take before it is sent again
+	speculationSlack = 3
+	// minDeadline stops fragments being sent again just because a turn was a bit slower than usual
+	minDeadline = 50 * time.Millisecond
+	// untimedDeadline is the deadline for workers we haven't timed yet
+	untimedDeadline = time.Second
+	// timeoutSlack is how many times longer than expected a call can take before its worker is treated as lost
+	timeoutSlack = 20
+)
+
+// speculation tracks the fragments of a turn that have been sent to workers
+type speculation struct {
+	workers []*worker
+	// When each fragment was first sent, and when it should be sent again
+	issued    []time.Time
+	deadlines []time.Time
+	rows      []int
+	// pending is how many workers are working out each fragment
+	pending []int
+	// done is set once a fragment has come back
+	done []bool
+	// speculated is set once a fragment has been sent to a second worker
+	speculated []bool
+	// busy is how many fragments each worker is working out
+	busy map[*worker]int
+	// lost is set for workers that have failed this turn, so they aren't sent any more fragments
+	lost map[*worker]bool
+	// retries is how many fragments have been sent to a second worker
+	retries int
+}
+
+// Start tracking the fragments sent to a set of workers
+func newSpeculation(workers []*worker) *speculation {
+	n := len(workers)
+	return &speculation{
+		workers:    workers,
+		issued:     make([]time.Time, n),
+		deadlines:  make([]time.Time, n),
+		rows:       make([]int, n),
+		pending:    make([]int, n),
+		done:       make([]bool, n),
+		speculated: make([]bool, n),
+		busy:       make(map[*worker]int),
+		lost:       make(map[*worker]bool),
+	}
+}
+
+// Get how long a worker should take to work out some rows
+func fragmentDeadline(w *worker, rows int) time.Duration {
+	speed := w.getSpeed()
+	if speed == 0 {
+		return untimedDeadline
+	}
+	deadline := time.Duration(speculationSlack * float64(rows) / speed * float64(time.Second))
+	if deadline < minDeadline {
+		return minDeadline
+	}
+	return deadline
+}
+
+// Get how long a worker has to answer a call about some rows before it is treated as lost
+func callTimeout(w *worker, rows int) time.Duration {
+	speed := w.getSpeed()
+	if speed == 0 {
+		return workerTimeout
+	}
+	timeout := time.Duration(timeoutSlack * float64(rows) / speed * float64(time.Second))
+	if timeout < workerTimeout {
+		return workerTimeout
+	}
+	return timeout
+}
+
+// Call a worker, giving up if it hasn't answered within a timeout
+// The reply mustn't be used if there is an error, since a late answer may still be written to it
+func callWorker(w *worker, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	call := w.Client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		return fmt.Errorf("no answer to %v within %v", method, timeout)
+	}
+}
+
+// Record that a fragment has been sent to its worker
+func (sp *speculation) sent(frag int, w *worker, rows int) {
+	now := time.Now()
+	sp.issued[frag] = now
+	sp.deadlines[frag] = now.Add(fragmentDeadline(w, rows))
+	sp.rows[frag] = rows
+	sp.pending[frag]++
+	sp.busy[w]++
+}
+
+// Record that a worker has come back with a fragment (or an error)
+// Returns false if the fragment has already come back from another worker, so the result should be ignored
+func (sp *speculation) received(frag int, w *worker) bool {
+	sp.pending[frag]--
+	sp.busy[w]--
+	return !sp.done[frag]
+}
+
+// Returns true if another worker is still working out a fragment
+func (sp *speculation) inProgress(frag int) bool {
+	return sp.pending[frag] > 0
+}
+
+// Record that a fragment has been accepted from a worker
+func (sp *speculation) finished(frag int, w *worker) {
+	sp.done[frag] = true
+	if w != sp.workers[frag] {
+		// The fragment's own worker was beaten, so make sure it is given fewer rows next time
+		sp.workers[frag].recordTurn(sp.rows[frag], time.Since(sp.issued[frag]))
+	}
+}
+
+// Check if a fragment is past its deadline and should be sent to another worker
+// Returns the idle worker it should be sent to, or nil if it shouldn't be sent again
+func (sp *speculation) overdue(frag int) *worker {
+	if sp.done[frag] || sp.speculated[frag] || time.Now().Before(sp.deadlines[frag]) {
+		return nil
+	}
+	// Use the fastest worker that has nothing to do
+	var idle *worker
+	for _, w := range sp.workers {
+		if sp.busy[w] > 0 || sp.lost[w] || w == sp.workers[frag] {
+			continue
+		}
+		if idle == nil || w.getSpeed() > idle.getSpeed() {
+			idle = w
+		}
+	}
+	if idle == nil {
+		return nil
+	}
+	println("Fragment from worker", sp.workers[frag].Address, "is taking too long, also sending it to", idle.Address)
+	sp.speculated[frag] = true
+	sp.retries++
+	sp.pending[frag]++
+	sp.busy[idle]++
+	return idle
+}
+
+// Record that a worker has failed, so it isn't sent any more fragments this turn
+func (sp *speculation) failed(w *worker) {
+	sp.lost[w] = true
+}
+
+// Send a fragment whose worker failed to another worker
+// Returns the worker it should be sent to, or nil if every worker has failed
+func (sp *speculation) reassign(frag int) *worker {
+	// Use the worker with the fewest fragments to work out, and the fastest of those
+	var other *worker
+	for _, w := range sp.workers {
+		if sp.lost[w] {
+			continue
+		}
+		if other == nil || sp.busy[w] < sp.busy[other] ||
+			(sp.busy[w] == sp.busy[other] && w.getSpeed() > other.getSpeed()) {
+			other = w
+		}
+	}
+	if other == nil {
+		return nil
+	}
+	println("Sending the fragment from worker", sp.workers[frag].Address, "to", other.Address)
+	sp.pending[frag]++
+	sp.busy[other]++
+	return other
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: live board streaming
+// Any number of viewers can watch the game with Server-Sent Events on GET /stream
+// Each viewer is first sent the whole board, then only the cells that change each turn
+
+/////////
+
+// subscriber is a viewer connected to the stream
+// If a viewer can't keep up, its events are dropped and it is sent the whole board again
+// once it has caught up
+type subscriber struct {
+	events chan streamEvent
+	resync bool
+}
+
+// streamEvent is a single Server-Sent Event
+type streamEvent struct {
+	Name string
+	Data []byte
+}
+
+// streamBoard is the data for a "board" event, which contains every cell
+// Cells holds one byte for the state of each cell, row by row, encoded in base64
+type streamBoard struct {
+	Turn   int    `json:"turn"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	States int    `json:"states"`
+	Cells  string `json:"cells"`
+}
+
+// streamTurn is the data for a "turn" event, which contains the cells that changed
+// Cells holds the x, y and new state of each cell in turn
+type streamTurn struct {
+	Turn  int   `json:"turn"`
+	Cells []int `json:"cells"`
+}
+
+// streamState is the data for a "state" event, sent when the game is paused, resumed or ends
+type streamState struct {
+	Turn  int    `json:"turn"`
+	State string `json:"state"`
+}
+
+// Make a "board" event for the whole board
+func boardEvent(turn int, board [][]uint8, states int) streamEvent {
+	height := len(board)
+	width := 0
+	if height > 0 {
+		width = len(board[0])
+	}
+	cells := make([]byte, 0, height*width)
+	for row := range board {
+		cells = append(cells, board[row]...)
+	}
+	data, _ := json.Marshal(streamBoard{
+		Turn:   turn,
+		Width:  width,
+		Height: height,
+		States: states,
+		Cells:  base64.StdEncoding.EncodeToString(cells),
+	})
+	return streamEvent{Name: "board", Data: data}
+}
+
+// Send an event to a subscriber without blocking
+// Returns false if the subscriber's buffer is full
+func (s *subscriber) send(event streamEvent) bool {
+	select {
+	case s.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Send the changes between two turns to every subscriber of the session
+// Subscribers who missed an event are sent the whole of the new board instead
+func (s *session) publishTurn(turn int, board, newBoard [][]uint8, rule stubs.Rule) {
+	s.SubscribersMutex.Lock()
+	defer s.SubscribersMutex.Unlock()
+	// Don't do any work if nobody is watching
+	if len(s.Subscribers) == 0 {
+		return
+	}
+
+	// Find the cells that have changed
+	changed := make([]int, 0)
+	for row := range board {
+		for col := range board[row] {
+			if board[row][col] != newBoard[row][col] {
+				changed = append(changed, col, row, int(newBoard[row][col]))
+			}
+		}
+	}
+	data, _ := json.Marshal(streamTurn{Turn: turn, Cells: changed})
+	event := streamEvent{Name: "turn", Data: data}
+
+	var keyframe *streamEvent
+	for sub := range s.Subscribers {
+		if sub.resync {
+			// Only make the board event once, however many subscribers need it
+			if keyframe == nil {
+				e := boardEvent(turn, newBoard, rule.NumStates())
+				keyframe = &e
+			}
+			sub.resync = !sub.send(*keyframe)
+		} else {
+			sub.resync = !sub.send(event)
+		}
+	}
+}
+
+// Returns true if anyone is watching the session's stream
+func (s *session) watched() bool {
+	s.SubscribersMutex.Lock()
+	defer s.SubscribersMutex.Unlock()
+	return len(s.Subscribers) > 0
+}
+
+// Send the whole board to every subscriber of the session (e.g. when a game starts or the board is randomised)
+func (s *session) publishBoard(turn int, board [][]uint8, rule stubs.Rule) {
+	s.SubscribersMutex.Lock()
+	defer s.SubscribersMutex.Unlock()
+	if len(s.Subscribers) == 0 {
+		return
+	}
+	event := boardEvent(turn, board, rule.NumStates())
+	for sub := range s.Subscribers {
+		sub.resync = !sub.send(event)
+	}
+}
+
+// Tell every subscriber of the session the game has changed state
+func (s *session) publishState(turn int, state string) {
+	s.SubscribersMutex.Lock()
+	defer s.SubscribersMutex.Unlock()
+	data, _ := json.Marshal(streamState{Turn: turn, State: state})
+	for sub := range s.Subscribers {
+		sub.send(streamEvent{Name: "state", Data: data})
+	}
+}
+
+// GET /stream?session=ID sends a session's game to a viewer as Server-Sent Events until they disconnect
+// Without a session the most recent one is streamed
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	s := requestSession(w, r)
+	if s == nil {
+		return
+	}
+	println("Viewer connected to the stream of session", s.ID)
+
+	// Start the viewer off with the current board
+	// Holding the session mutex means no turns can be published until we are subscribed
+	sub := &subscriber{events: make(chan streamEvent, 64)}
+	s.Mutex.Lock()
+	if s.Board != nil {
+		sub.send(boardEvent(s.Turn, s.Board, s.Info.Rule.NumStates()))
+		// Let them know if the game isn't running right now
+		state := ""
+		if !s.Info.Running {
+			state = "Finished"
+		} else if s.Info.Paused {
+			state = stubs.Paused.String()
+		}
+		if state != "" {
+			data, _ := json.Marshal(streamState{Turn: s.Turn, State: state})
+			sub.send(streamEvent{Name: "state", Data: data})
+		}
+	}
+	s.SubscribersMutex.Lock()
+	s.Subscribers[sub] = true
+	s.SubscribersMutex.Unlock()
+	s.Mutex.Unlock()
+
+	// Unsubscribe when the viewer goes away
+	defer func() {
+		s.SubscribersMutex.Lock()
+		delete(s.Subscribers, sub)
+		s.SubscribersMutex.Unlock()
+		println("Viewer disconnected from the stream of session", s.ID)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-sub.events:
+			_, err := w.Write([]byte("event: " + event.Name + "\ndata: " + string(event.Data) + "\n\n"))
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// GET / serves the board viewer page
+func handleViewer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(viewerHTML))
+}
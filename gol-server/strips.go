@@ -0,0 +1,516 @@
+package main
+
+import (
+	"net/rpc"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+/////////
+
+// EXTENSION: persistent strips
+// Each worker keeps its strip of the board between turns, so each turn we only send the rows just off
+// the top and bottom of every strip, and only get back each strip's new first and last rows
+// The whole board is only pulled from the workers when it is needed (saving, alive counts...)
+// EXTENSION: when every turn is needed (the history, visual updates, viewers...), the workers send back the rows
+// that changed along with each turn, so the board stays up to date without getting every strip
+// If a worker is lost its strip is gone, so if we have the whole board for the turn only that strip is
+// worked out again on another worker, otherwise the game goes back to the last turn we have the whole board for
+// EXTENSION: speculative retries work the same way, a strip that is taking too long is also made on an idle worker
+// from the board, so it only happens while we have the whole board for the turn
+// EXTENSION: workers can also get the rows around their strips straight from each other, so they only
+// tell us when they have finished a turn
+
+/////////
+
+var (
+	// usePersistentStrips lets workers keep their strips between turns, instead of being sent them every turn
+	usePersistentStrips bool
+	// usePeers lets workers send the rows between their strips to each other, instead of through us
+	usePeers bool
+)
+
+// stripSet stores how the board is shared out between the workers
+type stripSet struct {
+	workers []*worker
+	starts  []int
+	ends    []int
+	// edges is a copy of the board where only the first and last row of each strip are kept up to date
+	// (and the first and last cell of every row on a cross-surface), which is all the strips need from each other
+	edges [][]uint8
+	// hash is the hash of the board on the turn the strips are on
+	hash uint64
+	// peers is set if the workers get the rows between their strips from each other
+	peers bool
+	// balancedAt is when the strips were last shared out or checked against the workers' speeds
+	balancedAt time.Time
+	// moved is set if a strip has been made on another worker, so the board should be shared out again
+	moved bool
+}
+
+// Get the workers the session's board should be shared out between
+func (g *gameLoop) stripWorkers() []*worker {
+	workersMutex.Lock()
+	// Copy the workers, since the workers slice is changed in place when one disconnects
+	share := append([]*worker{}, sessionWorkers(g.s)...)
+	workersMutex.Unlock()
+	// Every strip needs at least one row
+	if len(share) > g.height {
+		share = share[:g.height]
+	}
+	return share
+}
+
+// Call every strip's worker at once
+// Returns false if any of them failed, disconnecting the workers that can't be reached
+func (set *stripSet) callAll(call func(i int, worker *worker) error) bool {
+	var wg sync.WaitGroup
+	failed := make([]bool, len(set.workers))
+	for i := range set.workers {
+		wg.Add(1)
+		go func(i int, worker *worker) {
+			defer wg.Done()
+			err := call(i, worker)
+			if err != nil {
+				workerFailed(worker, err)
+				failed[i] = true
+			}
+		}(i, set.workers[i])
+	}
+	wg.Wait()
+	for _, fail := range failed {
+		if fail {
+			return false
+		}
+	}
+	return true
+}
+
+// Report an error from a strip's worker, disconnecting it if it has been lost
+func workerFailed(worker *worker, err error) {
+	println("Error from worker", worker.Address, err.Error())
+	if workerLost(err) {
+		disconnectWorker(worker)
+	}
+}
+
+// Returns true if an error means a worker can't be reached any more
+// An error from the worker itself (e.g. it has been restarted and lost its strip) means it is still there
+func workerLost(err error) bool {
+	_, ok := err.(rpc.ServerError)
+	return !ok
+}
+
+// Find the worker with a row just off the edge of a strip, for workers getting rows from each other
+// Returns nil if the row is off the edge of the board, so it is always dead
+func (set *stripSet) rowPeer(row int, height, width int, topology stubs.Topology) *stubs.StripPeer {
+	x, y, onBoard := topology.Wrap(0, row, width, height)
+	if !onBoard {
+		return nil
+	}
+	for i := range set.workers {
+		if y >= set.starts[i] && y < set.ends[i] {
+			return &stubs.StripPeer{
+				Address: set.workers[i].Address,
+				Start:   set.starts[i],
+				// The row is always the first or last row of the strip it is in
+				Bottom: y == set.ends[i]-1,
+				// If the first cell has come from the other end of the row, the row is mirrored
+				Mirror: x != 0,
+			}
+		}
+	}
+	return nil
+}
+
+// Share the board out between the workers
+// The board must be up to date
+// Returns false if there are no workers or one of them failed
+func (g *gameLoop) loadStrips() bool {
+	share := g.stripWorkers()
+	numWorkers := len(share)
+	if numWorkers == 0 {
+		return false
+	}
+	set := &stripSet{
+		workers:    share,
+		edges:      make([][]uint8, g.height),
+		hash:       util.HashBoard(g.board),
+		peers:      usePeers,
+		balancedAt: time.Now(),
+	}
+	for row := range set.edges {
+		set.edges[row] = make([]uint8, g.width)
+		copy(set.edges[row], g.board[row])
+		// Rows pulled with a turn are put in the new board, so the rest of it must match the board
+		copy(g.newBoard[row], g.board[row])
+	}
+	// Split the rows the same way as updateBoard does, giving faster workers more
+	set.starts, set.ends = partitionRows(g.height, workerWeights(share))
+
+	println("Sharing out the board between", numWorkers, "workers")
+	ok := set.callAll(func(i int, worker *worker) error {
+		start, end := set.starts[i], set.ends[i]
+		req := g.loadRequest(start, end)
+		// Any strips the worker still has from before, e.g. from a turn it was too slow for, are no use now
+		req.Replace = true
+		req.Peers = set.peers
+		if set.peers {
+			req.Above = set.rowPeer(start-1, g.height, g.width, g.topology)
+			req.Below = set.rowPeer(end, g.height, g.width, g.topology)
+		}
+		return callWorker(worker, stubs.WorkerLoadStrip, req, &stubs.Empty{}, callTimeout(worker, end-start))
+	})
+	if !ok {
+		return false
+	}
+	g.strips = set
+	g.syncedTurn = g.turn
+	g.tiles.setAll()
+	return true
+}
+
+// Make the request to give a worker a strip of the board, which must be up to date
+func (g *gameLoop) loadRequest(start, end int) stubs.LoadStripRequest {
+	return stubs.LoadStripRequest{
+		SessionID: g.s.ID,
+		Strip: stubs.Fragment{
+			StartRow: start,
+			EndRow:   end,
+			Board:    stubs.StateBoardFromSlice(g.board[start:end], end-start, g.width, g.rule.NumStates()),
+		},
+		Rule:     g.rule,
+		Topology: g.topology,
+		Threads:  g.threads,
+		Turn:     g.turn,
+	}
+}
+
+// stripAttempt is the result of moving a strip on a turn on a worker
+// A strip may be moved on by more than one worker if the first is too slow
+type stripAttempt struct {
+	strip    int
+	worker   *worker
+	response stubs.StepStripResponse
+	err      error
+}
+
+// Move every strip on a turn, sending each worker the rows around its strip unless they get them from each other
+// If pull is set, the rows that changed are put in the new board
+// EXTENSION: if we have the board for this turn, a strip that takes too long is also made on an idle worker
+// and whichever is first is used, and only the strip of a worker that fails is made again on another worker
+// Returns false if one of the workers failed and its strip couldn't be made again, in which case the strips
+// can't be used any more
+func (g *gameLoop) stepStrips(pull bool) bool {
+	set := g.strips
+	// Only hash the board while we are still looking for a cycle
+	hash := cycleWindow > 0 && !g.cycleFound
+	numStrips := len(set.workers)
+
+	// Each strip is moved on at most twice, so the channel never blocks, even once we stop listening
+	results := make(chan stripAttempt, 2*numStrips)
+	turn := newSpeculation(set.workers)
+	for i, worker := range set.workers {
+		turn.sent(i, worker, set.ends[i]-set.starts[i])
+		go g.sendStep(i, worker, false, hash, pull, results)
+	}
+
+	// Check for strips that are taking too long every so often
+	check := time.NewTicker(speculationCheck)
+	defer check.Stop()
+	responses := make([]stubs.StepStripResponse, numStrips)
+	winners := make([]*worker, numStrips)
+	remaining := numStrips
+	for remaining > 0 {
+		select {
+		case result := <-results:
+			if !turn.received(result.strip, result.worker) {
+				// Another worker got there first
+				continue
+			}
+			if result.err != nil {
+				// A worker that is still there can be given the strip again
+				if workerLost(result.err) {
+					turn.failed(result.worker)
+				}
+				// Nothing to do if another worker is still moving this strip on
+				if turn.inProgress(result.strip) {
+					continue
+				}
+				// EXTENSION: keep the strips that have moved on, and only make this one again on a worker that is still there
+				// A strip can only be made again if we have the board for this turn
+				if g.syncedTurn == g.turn {
+					if other := turn.reassign(result.strip); other != nil {
+						go g.sendStep(result.strip, other, true, hash, pull, results)
+						continue
+					}
+				}
+				return false
+			}
+			turn.finished(result.strip, result.worker)
+			responses[result.strip] = result.response
+			winners[result.strip] = result.worker
+			remaining--
+		case <-check.C:
+			if !useSpeculation || g.syncedTurn != g.turn {
+				break
+			}
+			// Make any strips that are overdue on an idle worker as well
+			for i := range set.workers {
+				if idle := turn.overdue(i); idle != nil {
+					go g.sendStep(i, idle, true, hash, pull, results)
+				}
+			}
+		}
+	}
+	// Strips that have been made on another worker stay there, until the board is shared out again next turn
+	for i, winner := range winners {
+		if winner != set.workers[i] {
+			set.workers[i] = winner
+			set.moved = true
+		}
+	}
+
+	// Every strip has moved on, so the edges can be updated for next turn
+	g.tiles.clearChanged()
+	set.hash = 0
+	for i, response := range responses {
+		start, end := set.starts[i], set.ends[i]
+		if response.Top != nil {
+			copy(set.edges[start], response.Top.ToSlice()[0])
+			copy(set.edges[end-1], response.Bottom.ToSlice()[0])
+		}
+		if response.Sides != nil {
+			sides := response.Sides.ToSlice()
+			for row := start; row < end; row++ {
+				set.edges[row][0] = sides[row-start][0]
+				set.edges[row][g.width-1] = sides[row-start][1]
+			}
+		}
+		if pull && response.Rows != nil {
+			cells := response.Rows.ToSlice()
+			for k, row := range response.Changed.RowsSet(start, end) {
+				copy(g.newBoard[row], cells[k])
+			}
+		}
+		g.tiles.mark(response.Changed)
+		set.hash += response.Hash
+	}
+	g.tiles.advance()
+	return true
+}
+
+// Move a strip on a turn on a worker, and send the result down the results channel
+// If load is set, the strip is made on the worker from the board first, so the board must be on this turn,
+// and the worker is sent the rows around the strip, since its peers would still be looking for the strip's old worker
+func (g *gameLoop) sendStep(i int, worker *worker, load, hash, pull bool, results chan<- stripAttempt) {
+	result := stripAttempt{strip: i, worker: worker}
+	set := g.strips
+	start, end := set.starts[i], set.ends[i]
+	req := g.stepRequest(i, set.peers, set.edges, hash, pull)
+	if load {
+		result.err = callWorker(worker, stubs.WorkerLoadStrip, g.loadRequest(start, end), &stubs.Empty{}, callTimeout(worker, end-start))
+		req = g.stepRequest(i, false, g.board, hash, pull)
+	}
+	if result.err == nil {
+		result.err = g.stepStrip(i, worker, req, &result.response)
+	}
+	if result.err != nil {
+		workerFailed(worker, result.err)
+	}
+	results <- result
+}
+
+// Make the request to move a strip on a turn
+// Unless the worker gets them from its peers, the rows around the strip come from rows,
+// which must have the first and last row of every strip on this turn
+func (g *gameLoop) stepRequest(i int, peers bool, rows [][]uint8, hash, pull bool) stubs.StepStripRequest {
+	start, end := g.strips.starts[i], g.strips.ends[i]
+	states := g.rule.NumStates()
+	req := stubs.StepStripRequest{
+		SessionID: g.s.ID,
+		Start:     start,
+		Turn:      g.turn,
+		Active:    g.tiles.mask(start, end),
+		Hash:      hash,
+		Pull:      pull,
+	}
+	if !peers {
+		above := kernel.HaloRow(start-1, g.height, g.width, rows, g.topology)
+		below := kernel.HaloRow(end, g.height, g.width, rows, g.topology)
+		req.Above = stubs.StateBoardFromSlice([][]uint8{above}, 1, g.width, states)
+		req.Below = stubs.StateBoardFromSlice([][]uint8{below}, 1, g.width, states)
+	}
+	// The cells off the sides of a cross-surface come from all over the board, so they always go through us
+	if g.topology == stubs.CrossSurface {
+		req.Edges = kernel.HaloEdges(start, end, g.height, g.width, rows, g.topology, g.rule)
+	}
+	return req
+}
+
+// Ask a worker to move a strip on a turn
+func (g *gameLoop) stepStrip(i int, worker *worker, req stubs.StepStripRequest, res *stubs.StepStripResponse) error {
+	rows := g.strips.ends[i] - g.strips.starts[i]
+	before := time.Now()
+	var response stubs.StepStripResponse
+	err := callWorker(worker, stubs.WorkerStepStrip, req, &response, callTimeout(worker, rows))
+	if err != nil {
+		return err
+	}
+	if response.LostPeer != "" {
+		// The worker is fine, but one of its peers has gone, so disconnect them as if we had lost them
+		disconnectAddress(response.LostPeer)
+		return rpc.ServerError("lost peer " + response.LostPeer)
+	}
+	worker.recordTurn(rows, time.Since(before))
+	*res = response
+	return nil
+}
+
+// Disconnect the worker with an address
+func disconnectAddress(address string) {
+	workersMutex.Lock()
+	var found *worker
+	for _, worker := range workers {
+		if worker.Address == address {
+			found = worker
+		}
+	}
+	workersMutex.Unlock()
+	if found != nil {
+		disconnectWorker(found)
+	}
+}
+
+// Get the whole board from the workers
+// Returns false if one of the workers failed
+func (g *gameLoop) pullStrips(board [][]uint8) bool {
+	set := g.strips
+	frags := make([]stubs.Fragment, len(set.workers))
+	ok := set.callAll(func(i int, worker *worker) error {
+		return callWorker(worker, stubs.WorkerGetStrip, stubs.StripRequest{SessionID: g.s.ID, Start: set.starts[i]}, &frags[i],
+			callTimeout(worker, set.ends[i]-set.starts[i]))
+	})
+	if !ok {
+		return false
+	}
+	for _, frag := range frags {
+		cells := frag.Board.ToSlice()
+		for row := frag.StartRow; row < frag.EndRow; row++ {
+			copy(board[row], cells[row-frag.StartRow])
+		}
+	}
+	return true
+}
+
+// Tell the workers we don't need their strips any more, e.g. when the board is changed or the game ends
+func (g *gameLoop) dropStrips() {
+	if g.strips == nil {
+		return
+	}
+	set := g.strips
+	set.callAll(func(i int, worker *worker) error {
+		return callWorker(worker, stubs.WorkerDropStrip, stubs.StripRequest{SessionID: g.s.ID, Start: set.starts[i]}, &stubs.Empty{}, workerTimeout)
+	})
+	g.strips = nil
+}
+
+// Returns true if workers have joined or left since the board was shared out, or a strip has been moved
+func (g *gameLoop) stripsOutdated() bool {
+	if g.strips.moved {
+		return true
+	}
+	share := g.stripWorkers()
+	if len(share) != len(g.strips.workers) {
+		return true
+	}
+	for i := range share {
+		if share[i] != g.strips.workers[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Go back to the last turn we have the whole board for, because a strip has been lost
+func (g *gameLoop) rollback() {
+	println("Lost a strip, going back to turn", g.syncedTurn)
+	g.dropStrips()
+	g.turn = g.syncedTurn
+	g.tiles.setAll()
+}
+
+// Compute the next turn with the workers' strips
+// Returns false if there was a problem, in which case the turn can be retried
+func (g *gameLoop) nextStripTurn() bool {
+	s := g.s
+	// If workers have joined or left, or their speeds have changed, get the board back so it can be shared out again
+	if g.strips != nil && (g.stripsOutdated() || g.stripsUnbalanced()) {
+		println("Workers have changed")
+		g.syncBoard()
+		g.dropStrips()
+	}
+	if g.strips == nil && !g.loadStrips() {
+		println("Encountered a problem sharing out turn", g.turn)
+		return false
+	}
+	// The history, the controller's window, stream viewers and stepping while paused need every turn,
+	// so the board has to be up to date before the rows that change are pulled with the turn
+	pull := g.needsEveryTurn()
+	if pull && g.syncedTurn != g.turn {
+		g.syncBoard()
+		if g.syncedTurn != g.turn {
+			// Getting the board failed, so we have gone back
+			return false
+		}
+	}
+	if !g.stepStrips(pull) {
+		println("Encountered a problem handling turn", g.turn)
+		g.rollback()
+		return false
+	}
+	g.turn++
+	s.Mutex.Lock()
+	s.Info.Tiles = g.tiles.last
+	s.Mutex.Unlock()
+
+	if pull {
+		g.applyPulledRows()
+		if g.visualUpdates {
+			// Turns are numbered the same as when the workers are sent the whole board
+			s.callController(stubs.ControllerTurnComplete,
+				stubs.BoardStateReport{
+					CompletedTurns: g.turn - 1,
+					Board:          stubs.StateBoardFromSlice(g.board, g.height, g.width, g.rule.NumStates()),
+					Tiles:          g.tiles.last,
+				})
+		}
+	}
+	g.checkCycle()
+	return true
+}
+
+// Returns true if the board is needed on every turn, rather than only when it is asked for
+func (g *gameLoop) needsEveryTurn() bool {
+	return historyLength > 0 || g.visualUpdates || g.paused || g.s.watched()
+}
+
+// Copy the rows pulled with the last turn into the board, so it is up to date without asking for the strips
+func (g *gameLoop) applyPulledRows() {
+	s := g.s
+	s.Mutex.Lock()
+	// Send the changed cells to anyone watching the stream
+	s.publishTurn(g.turn, g.board, g.newBoard, g.rule)
+	for row := 0; row < g.height; row++ {
+		copy(g.board[row], g.newBoard[row])
+	}
+	s.Board = g.board
+	s.Turn = g.turn
+	s.Mutex.Unlock()
+	s.History.add(g.turn, g.board, g.rule)
+	g.syncedTurn = g.turn
+}
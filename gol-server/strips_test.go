@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// fakeStripWorker keeps strips like a real worker, one cell at a time
+type fakeStripWorker struct {
+	fakeWorker
+	// dieAt makes the worker die part way through a turn once it has moved its strips on that many times
+	dieAt int
+	// hangAt makes the worker wait for hang to be closed once it has moved its strips on that many times
+	hangAt int
+	hung   bool
+	// strips maps session IDs and first rows to the strip, and cells to its rows,
+	// with a row above and below for the rows around it
+	strips map[string]*stubs.Fragment
+	cells  map[string][][]uint8
+	rules  map[string]stubs.Rule
+	topos  map[string]stubs.Topology
+}
+
+// LoadStrip keeps a strip of the board
+func (f *fakeStripWorker) LoadStrip(req stubs.LoadStripRequest, res *stubs.Empty) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n := req.Strip.EndRow - req.Strip.StartRow
+	cells := make([][]uint8, n+2)
+	copy(cells[1:], req.Strip.Board.ToSlice())
+	cells[0] = make([]uint8, req.Strip.Board.RowLength)
+	cells[n+1] = make([]uint8, req.Strip.Board.RowLength)
+	strip := req.Strip
+	key := fmt.Sprint(req.SessionID, "/", strip.StartRow)
+	f.strips[key] = &strip
+	f.cells[key] = cells
+	f.rules[key] = req.Rule
+	f.topos[key] = req.Topology
+	return nil
+}
+
+// StepStrip moves a strip on a turn, using the rows around it from the request
+func (f *fakeStripWorker) StepStrip(req stubs.StepStripRequest, res *stubs.StepStripResponse) error {
+	if f.dieAt > 0 && f.getTurns() == f.dieAt {
+		// Give the other workers time to finish the turn first, so the turn is part done
+		time.Sleep(50 * time.Millisecond)
+		f.kill()
+		return errors.New("killed")
+	}
+	if f.hangAt > 0 && f.hangNow() {
+		<-f.hang
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := fmt.Sprint(req.SessionID, "/", req.Start)
+	strip, ok := f.strips[key]
+	if !ok {
+		return errors.New("no strip")
+	}
+	f.turns++
+	cells, rule := f.cells[key], f.rules[key]
+	width, n := strip.Board.RowLength, strip.EndRow-strip.StartRow
+	cells[0], cells[n+1] = req.Above.ToSlice()[0], req.Below.ToSlice()[0]
+	halo := stubs.Halo{
+		Board:    &stubs.StateBoard{RowLength: width, NumRows: n + 2},
+		Offset:   1,
+		StartPtr: strip.StartRow,
+		EndPtr:   strip.EndRow,
+		Topology: f.topos[key],
+	}
+	board := stubs.StateBoardFromSlice(cells, n+2, width, rule.NumStates()).Decode()
+	newCells := make([][]uint8, n)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	kernel.UpdateRegion(0, n, halo, newCells, width, board, nil, rule, req.Active, &wg)
+	res.Changed = kernel.ChangedCells(halo, newCells, board)
+	copy(cells[1:], newCells)
+	res.Top = stubs.StateBoardFromSlice(newCells[:1], 1, width, rule.NumStates())
+	res.Bottom = stubs.StateBoardFromSlice(newCells[n-1:], 1, width, rule.NumStates())
+	if req.Pull {
+		pulled := make([][]uint8, 0)
+		for _, row := range res.Changed.RowsSet(strip.StartRow, strip.EndRow) {
+			pulled = append(pulled, newCells[row-strip.StartRow])
+		}
+		if len(pulled) > 0 {
+			res.Rows = stubs.StateBoardFromSlice(pulled, len(pulled), width, rule.NumStates())
+		}
+	}
+	return nil
+}
+
+// Returns true the first time the worker should hang
+func (f *fakeStripWorker) hangNow() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.hung || f.turns != f.hangAt {
+		return false
+	}
+	f.hung = true
+	return true
+}
+
+// GetStrip sends back the whole strip
+func (f *fakeStripWorker) GetStrip(req stubs.StripRequest, res *stubs.Fragment) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := fmt.Sprint(req.SessionID, "/", req.Start)
+	strip, ok := f.strips[key]
+	if !ok {
+		return errors.New("no strip")
+	}
+	n := strip.EndRow - strip.StartRow
+	res.StartRow, res.EndRow = strip.StartRow, strip.EndRow
+	res.Board = stubs.StateBoardFromSlice(f.cells[key][1:n+1], n, strip.Board.RowLength, f.rules[key].NumStates())
+	return nil
+}
+
+// DropStrip forgets a strip
+func (f *fakeStripWorker) DropStrip(req stubs.StripRequest, res *stubs.Empty) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.strips, fmt.Sprint(req.SessionID, "/", req.Start))
+	return nil
+}
+
+// Start a fake strip worker, and connect to it as if it had connected to us
+func startFakeStripWorker(t *testing.T, dieAt int) (*fakeStripWorker, *worker) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeStripWorker{
+		fakeWorker: fakeWorker{listener: listener},
+		dieAt:      dieAt,
+		strips:     make(map[string]*stubs.Fragment),
+		cells:      make(map[string][][]uint8),
+		rules:      make(map[string]stubs.Rule),
+		topos:      make(map[string]stubs.Topology),
+	}
+	server := rpc.NewServer()
+	server.RegisterName("Worker", f)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mutex.Lock()
+			f.conns = append(f.conns, conn)
+			f.mutex.Unlock()
+			go server.ServeConn(conn)
+		}
+	}()
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, &worker{Client: client, Address: listener.Addr().String(), Cores: 1}
+}
+
+// Connect fake strip workers and make a game loop for a board, with strips turned on
+// Returns the game loop and a function which disconnects the workers and turns strips off again
+func startStripGame(t *testing.T, board [][]uint8, dieAts []int) (*gameLoop, []*fakeStripWorker, func()) {
+	usePersistentStrips = true
+	fakes, connected := []*fakeStripWorker{}, []*worker{}
+	for _, dieAt := range dieAts {
+		f, w := startFakeStripWorker(t, dieAt)
+		fakes, connected = append(fakes, f), append(connected, w)
+	}
+	workersMutex.Lock()
+	workers = connected
+	workersMutex.Unlock()
+
+	height, width := len(board), len(board[0])
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	s := &session{ID: "strips", History: newHistory(historyLength), Subscribers: make(map[*subscriber]bool)}
+	start := emptyBoard(height, width)
+	for row := range board {
+		copy(start[row], board[row])
+	}
+	g := newGameLoop(s, start, 0, height, width, 1000, 1, false, false, rule, stubs.Torus)
+	s.History.add(g.turn, g.board, rule)
+	return g, fakes, func() {
+		g.dropStrips()
+		for _, f := range fakes {
+			f.kill()
+		}
+		workersMutex.Lock()
+		workers = make([]*worker, 0)
+		workersMutex.Unlock()
+		usePersistentStrips = false
+	}
+}
+
+// Work out the boards of the first turns of a game on a torus
+func expectedTurns(board [][]uint8, turns int) [][][]uint8 {
+	height, width := len(board), len(board[0])
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	tiles := newTileMap(height, width)
+	boards := [][][]uint8{board}
+	for turn := 0; turn < turns; turn++ {
+		next := emptyBoard(height, width)
+		updateBoardLocally(boards[turn], next, height, width, rule, stubs.Torus, tiles)
+		boards = append(boards, next)
+	}
+	return boards
+}
+
+// Check a board matches the one expected
+func assertBoard(t *testing.T, got, expected [][]uint8) {
+	t.Helper()
+	for row := range expected {
+		for col := range expected[row] {
+			if got[row][col] != expected[row][col] {
+				t.Fatalf("cell (%v, %v) is %v, expected %v", col, row, got[row][col], expected[row][col])
+			}
+		}
+	}
+}
+
+// TestStripHistory runs a game with strips, then pauses and steps back through the history
+func TestStripHistory(t *testing.T) {
+	historyLength = 10
+	defer func() { historyLength = 0 }()
+	board := randomBoard(48, 40)
+	expected := expectedTurns(board, 5)
+
+	g, fakes, stop := startStripGame(t, board, []int{0, 0})
+	defer stop()
+	for turn := 0; turn < 5; turn++ {
+		if !g.nextTurn() {
+			t.Fatal("turn", turn, "failed")
+		}
+	}
+	if g.strips == nil || fakes[0].getTurns() != 5 || fakes[1].getTurns() != 5 {
+		t.Fatal("the turns weren't worked out with strips")
+	}
+
+	g.handleKeypress('p')
+	for turn := 4; turn >= 2; turn-- {
+		g.handleKeypress('<')
+		if g.turn != turn {
+			t.Fatalf("stepped back to turn %v, expected %v", g.turn, turn)
+		}
+		assertBoard(t, g.board, expected[turn])
+	}
+	// Stepping forward goes back through the same turns
+	g.handleKeypress('>')
+	if g.turn != 3 {
+		t.Fatalf("stepped forward to turn %v, expected 3", g.turn)
+	}
+	assertBoard(t, g.board, expected[3])
+}
+
+// TestStripPartialRetry kills a worker part way through moving its strip on,
+// and checks only that strip is worked out again, by another worker
+func TestStripPartialRetry(t *testing.T) {
+	historyLength = 10
+	defer func() { historyLength = 0 }()
+	board := randomBoard(48, 40)
+	expected := expectedTurns(board, 6)
+
+	// The second worker dies on the fourth turn
+	g, fakes, stop := startStripGame(t, board, []int{0, 3, 0})
+	defer stop()
+	for turn := 0; turn < 6; turn++ {
+		if !g.nextTurn() {
+			t.Fatal("turn", turn, "failed")
+		}
+	}
+	if g.turn != 6 {
+		t.Fatal("game is on turn", g.turn, "rather than 6")
+	}
+	assertBoard(t, g.board, expected[6])
+	// The other two workers each move their strip on for the first four turns,
+	// one of them moves the lost strip on for the fourth turn, and then they share the board for the last two
+	if turns := fakes[0].getTurns() + fakes[2].getTurns(); turns != 4+4+1+2+2 {
+		t.Fatal("the other workers moved strips on", turns, "times, expected", 4+4+1+2+2)
+	}
+}
+
+// Run a game with strips where the second of three workers hangs on the fourth turn
+// Returns the game loop once it has moved on six turns, whether the hung worker is still connected,
+// and the longest a turn took
+func runHungStripGame(t *testing.T) (*gameLoop, bool, time.Duration) {
+	historyLength = 10
+	defer func() { historyLength = 0 }()
+	board := randomBoard(48, 40)
+	expected := expectedTurns(board, 6)
+
+	g, fakes, stop := startStripGame(t, board, []int{0, 0, 0})
+	defer stop()
+	fakes[1].hangAt = 3
+	fakes[1].hang = make(chan struct{})
+	defer close(fakes[1].hang)
+	workersMutex.Lock()
+	hung := workers[1]
+	workersMutex.Unlock()
+
+	longest := time.Duration(0)
+	for turn := 0; turn < 6; turn++ {
+		start := time.Now()
+		if !g.nextTurn() {
+			t.Fatal("turn", turn, "failed")
+		}
+		if took := time.Since(start); took > longest {
+			longest = took
+		}
+	}
+	assertBoard(t, g.board, expected[6])
+	connected := false
+	workersMutex.Lock()
+	for _, w := range workers {
+		connected = connected || w == hung
+	}
+	workersMutex.Unlock()
+	return g, connected, longest
+}
+
+// TestStripSpeculation checks a strip that is taking too long is made on an idle worker,
+// without waiting for the slow worker's deadline
+func TestStripSpeculation(t *testing.T) {
+	useSpeculation = true
+	defer func() { useSpeculation = false }()
+	_, connected, longest := runHungStripGame(t)
+	if longest > time.Second {
+		t.Errorf("a turn took %v, rather than the slow strip being made on another worker", longest)
+	}
+	if !connected {
+		t.Errorf("the slow worker was disconnected, rather than being beaten by another worker")
+	}
+}
+
+// TestStripDeadline checks a worker that hangs is treated as lost once it is past its deadline,
+// and its strip is made on another worker
+func TestStripDeadline(t *testing.T) {
+	_, connected, longest := runHungStripGame(t)
+	if longest > 2*time.Second {
+		t.Errorf("a turn took %v, the hung worker should have been given up on after %v", longest, workerTimeout)
+	}
+	if connected {
+		t.Errorf("the hung worker is still connected")
+	}
+}
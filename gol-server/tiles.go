@@ -0,0 +1,129 @@
+package main
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: active tiles
+// A cell can only change if a cell next to it (or itself) changed last turn
+// Workers tell us which tiles changed, and next turn they only work out those tiles and their neighbours
+
+/////////
+
+// tileMap tracks which tiles of the board need working out each turn
+type tileMap struct {
+	width   int
+	columns int
+	rows    int
+	// active is the tiles to work out this turn, if it is nil every tile is worked out
+	active []bool
+	// changed is the tiles the workers said changed this turn
+	changed []bool
+	// last is the tiles worked out on the last turn, sinceReport is the total since the last alive cells report
+	last        stubs.TileStats
+	sinceReport stubs.TileStats
+}
+
+// Make a tile map for a board, with every tile active
+func newTileMap(height, width int) *tileMap {
+	t := &tileMap{
+		width:   width,
+		columns: stubs.TileColumns(width),
+		rows:    stubs.TileRows(height),
+	}
+	t.changed = make([]bool, t.columns*t.rows)
+	return t
+}
+
+// Make every tile active, because the board has been changed without the workers
+func (t *tileMap) setAll() {
+	t.active = nil
+}
+
+// Get the active tiles for a worker working out board rows start to end
+func (t *tileMap) mask(start, end int) stubs.TileMask {
+	if t.active == nil {
+		return stubs.TileMask{}
+	}
+	mask := stubs.NewTileMask(start, end, t.width)
+	copy(mask.Tiles, t.active[mask.FirstRow*t.columns:])
+	return mask
+}
+
+// Forget the tiles marked as changed, e.g. before retrying a turn
+func (t *tileMap) clearChanged() {
+	for i := range t.changed {
+		t.changed[i] = false
+	}
+}
+
+// Add the tiles a worker said changed
+func (t *tileMap) mark(changed stubs.TileMask) {
+	// Workers that were sent every tile still only say what changed, so this is never nil
+	for i, set := range changed.Tiles {
+		if set {
+			t.changed[changed.FirstRow*t.columns+i] = true
+		}
+	}
+}
+
+// Move on to the next turn once every worker has finished
+// The tiles that changed and their neighbours become active, and the stats for the turn are recorded
+func (t *tileMap) advance() {
+	total := t.columns * t.rows
+	computed := total
+	if t.active != nil {
+		computed = 0
+		for _, set := range t.active {
+			if set {
+				computed++
+			}
+		}
+	}
+	t.last = stubs.TileStats{Computed: computed, Skipped: total - computed}
+	t.sinceReport.Computed += t.last.Computed
+	t.sinceReport.Skipped += t.last.Skipped
+
+	active := make([]bool, total)
+	edge := false
+	for row := 0; row < t.rows; row++ {
+		for col := 0; col < t.columns; col++ {
+			if !t.changed[row*t.columns+col] {
+				continue
+			}
+			// Activate the tile and the tiles around it that are on the board
+			for y := row - 1; y <= row+1; y++ {
+				for x := col - 1; x <= col+1; x++ {
+					if y >= 0 && y < t.rows && x >= 0 && x < t.columns {
+						active[y*t.columns+x] = true
+					}
+				}
+			}
+			// Tiles on the edge may be next to tiles on other edges, depending on the topology
+			if row == 0 || row == t.rows-1 || col == 0 || col == t.columns-1 {
+				edge = true
+			}
+		}
+	}
+	// Rather than working out which edge tiles each topology joins up, activate all of them
+	if edge {
+		for row := 0; row < t.rows; row++ {
+			for col := 0; col < t.columns; col++ {
+				if row == 0 || row == t.rows-1 || col == 0 || col == t.columns-1 {
+					active[row*t.columns+col] = true
+				}
+			}
+		}
+	}
+	t.active = active
+	t.clearChanged()
+}
+
+// Get the stats since the last report, and start counting again
+func (t *tileMap) report() stubs.TileStats {
+	stats := t.sinceReport
+	t.sinceReport = stubs.TileStats{}
+	return stats
+}
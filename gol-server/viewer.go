@@ -0,0 +1,102 @@
+package main
+
+// viewerHTML is the page served on GET /
+// It draws the board from /stream onto a canvas, one pixel per cell, scaled up to fit the window
+// Cells are coloured with the same greys as the SDL window and saved images
+const viewerHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Game of Life</title>
+<style>
+body { margin: 0; background: #222; color: #ddd; font-family: sans-serif; }
+#info { padding: 8px; }
+canvas { display: block; margin: 0 auto; image-rendering: pixelated; background: #000; }
+</style>
+</head>
+<body>
+<div id="info">Connecting...</div>
+<canvas id="board"></canvas>
+<script>
+var canvas = document.getElementById("board");
+var ctx = canvas.getContext("2d");
+var info = document.getElementById("info");
+var image = null;
+var width = 0, height = 0, states = 2, turn = 0, state = "Executing";
+
+// The same mapping as util.StateToGrey
+function grey(s) {
+	if (s === 0) return 0;
+	return 255 - Math.floor(((s - 1) * 255 + Math.floor((states - 1) / 2)) / (states - 1));
+}
+
+function setCell(x, y, s) {
+	var i = (y * width + x) * 4;
+	var g = grey(s);
+	image.data[i] = g;
+	image.data[i + 1] = g;
+	image.data[i + 2] = g;
+	image.data[i + 3] = 255;
+}
+
+// Scale the canvas up as far as it fits in the window
+function resize() {
+	if (width === 0) return;
+	var scale = Math.max(1, Math.floor(Math.min(window.innerWidth / width, (window.innerHeight - 40) / height)));
+	canvas.style.width = (width * scale) + "px";
+	canvas.style.height = (height * scale) + "px";
+}
+
+var dirty = false;
+function draw() {
+	if (dirty && image) {
+		ctx.putImageData(image, 0, 0);
+		info.textContent = "Turn " + turn + " - " + width + "x" + height + " - " + state;
+		dirty = false;
+	}
+	window.requestAnimationFrame(draw);
+}
+
+// Watch the same session as the page, e.g. /?session=2
+var stream = new EventSource("stream" + window.location.search);
+stream.addEventListener("board", function (e) {
+	var b = JSON.parse(e.data);
+	width = b.width;
+	height = b.height;
+	states = b.states;
+	turn = b.turn;
+	canvas.width = width;
+	canvas.height = height;
+	image = ctx.createImageData(width, height);
+	var cells = atob(b.cells);
+	for (var i = 0; i < cells.length; i++) {
+		setCell(i % width, Math.floor(i / width), cells.charCodeAt(i));
+	}
+	resize();
+	dirty = true;
+});
+stream.addEventListener("turn", function (e) {
+	if (!image) return;
+	var t = JSON.parse(e.data);
+	for (var i = 0; i < t.cells.length; i += 3) {
+		setCell(t.cells[i], t.cells[i + 1], t.cells[i + 2]);
+	}
+	turn = t.turn;
+	state = "Executing";
+	dirty = true;
+});
+stream.addEventListener("state", function (e) {
+	var s = JSON.parse(e.data);
+	turn = s.turn;
+	state = s.state;
+	dirty = true;
+});
+stream.onerror = function () {
+	info.textContent = "Disconnected, reconnecting...";
+};
+window.addEventListener("resize", resize);
+window.requestAnimationFrame(draw);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: persistent strips
+// We keep our strips of each session's board between turns, so the server only sends the rows
+// just off the top and bottom of it each turn, and we only send back our new first and last rows
+// The whole strip is only sent back when the server asks for it
+// EXTENSION: if the server gives us peers, we get the rows off the top and bottom from the workers with them instead
+
+/////////
+
+// strip is a part of a session's board we work out each turn
+type strip struct {
+	start    int
+	end      int
+	width    int
+	rule     stubs.Rule
+	topology stubs.Topology
+	threads  int
+	// For two state rules the strip is kept as packed rows, otherwise as cells
+	// Either way there is an extra row above and below, which is replaced by the server's rows each turn
+	rows  [][]uint64
+	cells [][]uint8
+
+	// peers is set if we get the rows off the top and bottom from other workers
+	// above and below are nil if those rows are off the edge of the board
+	peers bool
+	above *stubs.StripPeer
+	below *stubs.StripPeer
+
+	// mutex guards the turn and the first and last rows, since peers read them while we work out the next turn
+	mutex sync.Mutex
+	turn  int
+	// Peers may still need the first and last rows from the turn before
+	prevTop    []uint8
+	prevBottom []uint8
+}
+
+// peerTimeout is how long a peer has to send us a row before we tell the server it has been lost
+// Peers keep their rows from the turn before, so they answer straight away, well within the server's deadline for us
+const peerTimeout = time.Second
+
+var (
+	// strips maps session IDs and first rows, from stripKey, to our strips of their board
+	// We usually have one strip of a board, but the server can give us another worker's strip if it is lost
+	strips      = make(map[string]*strip)
+	stripsMutex sync.Mutex
+
+	// peerClients maps addresses to our connections to other workers
+	peerClients      = make(map[string]*rpc.Client)
+	peerClientsMutex sync.Mutex
+)
+
+// Get the key of a strip of a session's board in the strips map
+func stripKey(id string, start int) string {
+	return id + "/" + strconv.Itoa(start)
+}
+
+// Get our strip of a session's board starting at a row
+func getStrip(id string, start int) (*strip, error) {
+	stripsMutex.Lock()
+	defer stripsMutex.Unlock()
+	s, ok := strips[stripKey(id, start)]
+	if !ok {
+		return nil, fmt.Errorf("no strip at row %v for session %v", start, id)
+	}
+	return s, nil
+}
+
+// LoadStrip is called by the server to give us a strip of a session's board to keep
+func (w *Worker) LoadStrip(req stubs.LoadStripRequest, res *stubs.Empty) (err error) {
+	s := &strip{
+		start:    req.Strip.StartRow,
+		end:      req.Strip.EndRow,
+		width:    req.Strip.Board.RowLength,
+		rule:     req.Rule,
+		topology: req.Topology,
+		threads:  req.Threads,
+		peers:    req.Peers,
+		above:    req.Above,
+		below:    req.Below,
+		turn:     req.Turn,
+	}
+	n := s.end - s.start
+	if s.rule.NumStates() == 2 {
+		s.rows = make([][]uint64, n+2)
+		copy(s.rows[1:], req.Strip.Board.Planes[0].DecodeRows(n, s.width))
+		s.rows[0] = make([]uint64, stubs.WordsPerRow(s.width))
+		s.rows[n+1] = make([]uint64, stubs.WordsPerRow(s.width))
+	} else {
+		s.cells = make([][]uint8, n+2)
+		copy(s.cells[1:], req.Strip.Board.ToSlice())
+		s.cells[0] = make([]uint8, s.width)
+		s.cells[n+1] = make([]uint8, s.width)
+	}
+	println("Loaded rows", s.start, "to", s.end, "of session", req.SessionID)
+	stripsMutex.Lock()
+	if req.Replace {
+		for key := range strips {
+			if strings.HasPrefix(key, req.SessionID+"/") {
+				delete(strips, key)
+			}
+		}
+	}
+	strips[stripKey(req.SessionID, s.start)] = s
+	stripsMutex.Unlock()
+	return
+}
+
+// StepStrip is called by the server to move our strip of a session's board on a turn
+func (w *Worker) StepStrip(req stubs.StepStripRequest, res *stubs.StepStripResponse) (err error) {
+	s, err := getStrip(req.SessionID, req.Start)
+	if err != nil {
+		return err
+	}
+	if req.Turn != s.turn {
+		return fmt.Errorf("strip is on turn %v, not %v", s.turn, req.Turn)
+	}
+	n := s.end - s.start
+	above, below := req.Above, req.Below
+	if s.peers {
+		// Get both rows at once
+		var lostAbove, lostBelow string
+		var errAbove, errBelow error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			above, lostAbove, errAbove = fetchEdge(req.SessionID, s.above, req.Turn)
+			wg.Done()
+		}()
+		go func() {
+			below, lostBelow, errBelow = fetchEdge(req.SessionID, s.below, req.Turn)
+			wg.Done()
+		}()
+		wg.Wait()
+		// Let the server know if a peer has gone, so it can disconnect them
+		if lostAbove != "" {
+			res.LostPeer = lostAbove
+			return nil
+		}
+		if lostBelow != "" {
+			res.LostPeer = lostBelow
+			return nil
+		}
+		if errAbove != nil {
+			return errAbove
+		}
+		if errBelow != nil {
+			return errBelow
+		}
+	}
+
+	// The kernels only need the size of the halo, since we already have its cells
+	halo := stubs.Halo{
+		Board:    &stubs.StateBoard{RowLength: s.width, NumRows: n + 2},
+		Offset:   1,
+		StartPtr: s.start,
+		EndPtr:   s.end,
+		Topology: s.topology,
+	}
+	var edges [][]byte
+	if req.Edges != nil {
+		edges = req.Edges.Decode()
+	}
+
+	// Work out the new strip, keeping the old one until we know which tiles changed
+	var newCells [][]uint8
+	if s.rows != nil {
+		// Rows off the edge of the board are dead
+		s.rows[0] = make([]uint64, stubs.WordsPerRow(s.width))
+		s.rows[n+1] = make([]uint64, stubs.WordsPerRow(s.width))
+		if above != nil {
+			s.rows[0] = above.Planes[0].DecodeRows(1, s.width)[0]
+		}
+		if below != nil {
+			s.rows[n+1] = below.Planes[0].DecodeRows(1, s.width)[0]
+		}
+		newRows := make([][]uint64, n)
+		kernel.SplitRows(n, s.threads, func(start, end int, wg *sync.WaitGroup) {
+			kernel.UpdateRows(start, end, halo, newRows, s.rows, edges, s.rule, req.Active, wg)
+		})
+		res.Changed = kernel.ChangedRows(halo, newRows, s.rows)
+		s.mutex.Lock()
+		s.prevTop, s.prevBottom = s.edgeCells(false), s.edgeCells(true)
+		copy(s.rows[1:], newRows)
+		s.turn++
+		s.mutex.Unlock()
+		if !s.peers {
+			res.Top = stubs.StateBoardFromRows(newRows[:1], 1, s.width)
+			res.Bottom = stubs.StateBoardFromRows(newRows[n-1:], 1, s.width)
+		}
+		if req.Pull {
+			pulled := make([][]uint64, 0)
+			for _, row := range res.Changed.RowsSet(s.start, s.end) {
+				pulled = append(pulled, newRows[row-s.start])
+			}
+			if len(pulled) > 0 {
+				res.Rows = stubs.StateBoardFromRows(pulled, len(pulled), s.width)
+			}
+		}
+		// Only unpack the rows if we need their cells
+		if req.Hash || s.topology == stubs.CrossSurface {
+			newCells = make([][]uint8, n)
+			for row := range newRows {
+				newCells[row] = unpackRow(newRows[row], s.width)
+			}
+		}
+	} else {
+		s.cells[0] = make([]uint8, s.width)
+		s.cells[n+1] = make([]uint8, s.width)
+		if above != nil {
+			s.cells[0] = above.ToSlice()[0]
+		}
+		if below != nil {
+			s.cells[n+1] = below.ToSlice()[0]
+		}
+		board := stubs.StateBoardFromSlice(s.cells, n+2, s.width, s.rule.NumStates()).Decode()
+		newCells = make([][]uint8, n)
+		kernel.SplitRows(n, s.threads, func(start, end int, wg *sync.WaitGroup) {
+			kernel.UpdateRegion(start, end, halo, newCells, s.width, board, edges, s.rule, req.Active, wg)
+		})
+		res.Changed = kernel.ChangedCells(halo, newCells, board)
+		s.mutex.Lock()
+		s.prevTop, s.prevBottom = s.edgeCells(false), s.edgeCells(true)
+		copy(s.cells[1:], newCells)
+		s.turn++
+		s.mutex.Unlock()
+		if !s.peers {
+			res.Top = stubs.StateBoardFromSlice(newCells[:1], 1, s.width, s.rule.NumStates())
+			res.Bottom = stubs.StateBoardFromSlice(newCells[n-1:], 1, s.width, s.rule.NumStates())
+		}
+		if req.Pull {
+			pulled := make([][]uint8, 0)
+			for _, row := range res.Changed.RowsSet(s.start, s.end) {
+				pulled = append(pulled, newCells[row-s.start])
+			}
+			if len(pulled) > 0 {
+				res.Rows = stubs.StateBoardFromSlice(pulled, len(pulled), s.width, s.rule.NumStates())
+			}
+		}
+	}
+
+	// On a cross-surface, other strips need the cells on the left and right of our rows
+	if s.topology == stubs.CrossSurface {
+		sides := make([][]uint8, n)
+		for row := range sides {
+			sides[row] = []uint8{newCells[row][0], newCells[row][s.width-1]}
+		}
+		res.Sides = stubs.StateBoardFromSlice(sides, n, 2, s.rule.NumStates())
+	}
+	if req.Hash {
+		for row := range newCells {
+			res.Hash += stubs.HashRow(s.start+row, newCells[row])
+		}
+	}
+	return
+}
+
+// GetStrip is called by the server to get the whole of our strip of a session's board
+func (w *Worker) GetStrip(req stubs.StripRequest, res *stubs.Fragment) (err error) {
+	s, err := getStrip(req.SessionID, req.Start)
+	if err != nil {
+		return err
+	}
+	n := s.end - s.start
+	res.StartRow = s.start
+	res.EndRow = s.end
+	if s.rows != nil {
+		res.Board = stubs.StateBoardFromRows(s.rows[1:n+1], n, s.width)
+	} else {
+		res.Board = stubs.StateBoardFromSlice(s.cells[1:n+1], n, s.width, s.rule.NumStates())
+	}
+	return
+}
+
+// DropStrip is called by the server when it no longer needs our strip of a session's board
+func (w *Worker) DropStrip(req stubs.StripRequest, res *stubs.Empty) (err error) {
+	stripsMutex.Lock()
+	delete(strips, stripKey(req.SessionID, req.Start))
+	stripsMutex.Unlock()
+	return
+}
+
+// Unpack a packed row into cells
+func unpackRow(words []uint64, width int) []uint8 {
+	cells := make([]uint8, width)
+	for col := range cells {
+		cells[col] = uint8(words[col/64] >> uint(col%64) & 1)
+	}
+	return cells
+}
+
+// Get the cells of the strip's first or last row
+// The caller must hold the strip's mutex
+func (s *strip) edgeCells(bottom bool) []uint8 {
+	row := 1
+	if bottom {
+		row = s.end - s.start
+	}
+	if s.rows != nil {
+		return unpackRow(s.rows[row], s.width)
+	}
+	return s.cells[row]
+}
+
+// Get the strip's first or last row on a turn, for a peer
+func (s *strip) edge(req stubs.EdgeRequest) (*stubs.StateBoard, error) {
+	s.mutex.Lock()
+	var cells []uint8
+	switch req.Turn {
+	case s.turn:
+		cells = s.edgeCells(req.Bottom)
+	case s.turn - 1:
+		cells = s.prevTop
+		if req.Bottom {
+			cells = s.prevBottom
+		}
+	}
+	turn := s.turn
+	s.mutex.Unlock()
+	if cells == nil {
+		return nil, fmt.Errorf("strip is on turn %v, can't get a row from turn %v", turn, req.Turn)
+	}
+	// Crossing the top or bottom edge of some topologies mirrors the row
+	if req.Mirror {
+		mirrored := make([]uint8, len(cells))
+		for col := range cells {
+			mirrored[len(cells)-1-col] = cells[col]
+		}
+		cells = mirrored
+	}
+	return stubs.StateBoardFromSlice([][]uint8{cells}, 1, s.width, s.rule.NumStates()), nil
+}
+
+// GetEdge is called by other workers to get the first or last row of our strip of a session's board
+func (w *Worker) GetEdge(req stubs.EdgeRequest, res *stubs.StateBoard) (err error) {
+	s, err := getStrip(req.SessionID, req.Start)
+	if err != nil {
+		return err
+	}
+	row, err := s.edge(req)
+	if err != nil {
+		return err
+	}
+	*res = *row
+	return
+}
+
+// Get a row from a peer
+// Returns the peer's address as lost if we can't reach them, or an error if they couldn't give us the row
+// If there is no peer the row is off the edge of the board, so nil is returned
+func fetchEdge(id string, peer *stubs.StripPeer, turn int) (row *stubs.StateBoard, lost string, err error) {
+	if peer == nil {
+		return nil, "", nil
+	}
+	req := stubs.EdgeRequest{SessionID: id, Start: peer.Start, Turn: turn, Bottom: peer.Bottom, Mirror: peer.Mirror}
+	// We may be our own peer, e.g. the only worker on a torus
+	if peer.Address == ourAddress {
+		s, err := getStrip(id, peer.Start)
+		if err != nil {
+			return nil, "", err
+		}
+		row, err = s.edge(req)
+		return row, "", err
+	}
+
+	client, err := peerClient(peer.Address)
+	if err != nil {
+		println("Cannot reach peer", peer.Address, err.Error())
+		return nil, peer.Address, nil
+	}
+	row = new(stubs.StateBoard)
+	// Don't wait forever for a peer that has hung
+	call := client.Go(stubs.WorkerGetEdge, req, row, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(peerTimeout):
+		err = fmt.Errorf("no answer within %v", peerTimeout)
+	}
+	if err != nil {
+		// An error from the peer itself means it is still there
+		if _, ok := err.(rpc.ServerError); ok {
+			return nil, "", err
+		}
+		println("Lost peer", peer.Address, err.Error())
+		dropPeerClient(peer.Address)
+		return nil, peer.Address, nil
+	}
+	return row, "", nil
+}
+
+// Get our connection to a peer, connecting to them if we haven't already
+func peerClient(address string) (*rpc.Client, error) {
+	peerClientsMutex.Lock()
+	defer peerClientsMutex.Unlock()
+	if client, ok := peerClients[address]; ok {
+		return client, nil
+	}
+	client, err := rpc.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	peerClients[address] = client
+	return client, nil
+}
+
+// Forget our connection to a peer, so we connect again next time
+func dropPeerClient(address string) {
+	peerClientsMutex.Lock()
+	defer peerClientsMutex.Unlock()
+	if client, ok := peerClients[address]; ok {
+		client.Close()
+		delete(peerClients, address)
+	}
+}
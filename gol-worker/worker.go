@@ -5,9 +5,10 @@ import (
 	"net"
 	"net/rpc"
 	"os"
-	"sync"
+	"runtime"
 	"time"
 
+	"uk.ac.bris.cs/gameoflife/kernel"
 	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
@@ -17,6 +18,8 @@ var (
 	server        *rpc.Client
 	serverAddress string
 	ourAddress    string
+	// callback is the connection we opened for the server to call us on
+	callback net.Conn
 )
 
 // Worker is the struct for our RPC server
@@ -26,8 +29,9 @@ type Worker struct{}
 // It will pass the board and fragment pointers
 func (w *Worker) DoTurn(req stubs.DoTurnRequest, res *stubs.DoTurnResponse) (err error) {
 	// Get the turn result
-	frag := doTurn(req.Halo, req.Threads)
+	frag, changed := kernel.DoTurn(req.Halo, req.Threads, req.Rule, req.Active)
 	res.Frag = frag
+	res.Changed = changed
 	return
 }
 
@@ -46,6 +50,8 @@ func main() {
 	portPtr := flag.String("p", "8010", "port to listen on")
 	// Read in the localhost flag
 	localhost := flag.Bool("localhost", false, "set to true if we want to use localhost")
+	// EXTENSION: read in the address other workers should connect to us on
+	advertisePtr := flag.String("advertise", "", "address (host or host:port) other workers can reach us on, defaults to the interface we reach the server with")
 	// Read in the network address of the server, from the commandline
 	serverAddressPtr := flag.String("s", "localhost:8020", "server address")
 
@@ -55,8 +61,8 @@ func main() {
 	if *localhost {
 		ourAddress = "localhost:" + *portPtr
 	} else {
-		// Otherwise our address is our public IP
-		ourAddress = util.GetPublicIP() + ":" + *portPtr
+		// Otherwise our address is the one we were told to advertise, or the interface we reach the server with
+		ourAddress = util.AdvertisedAddress(*advertisePtr, *portPtr, *serverAddressPtr)
 	}
 	serverAddress = *serverAddressPtr
 	println("Starting worker (" + ourAddress + ")")
@@ -89,8 +95,7 @@ func main() {
 					println("Error pinging server:", err.Error())
 
 					// Close the connection anyway
-					server.Close()
-					server = nil
+					disconnectFromServer()
 					println("Disconnected")
 				}
 			} else {
@@ -117,14 +122,25 @@ func connectToServer() bool {
 	server = newServer
 	response := new(stubs.ServerResponse)
 
+	// EXTENSION: open a connection for the server to call us on, so it doesn't have to reach our address
+	// If this fails, the server can still dial us
+	newCallback, token, err := util.DialCallback(serverAddress, rpc.DefaultServer)
+	if err != nil {
+		println("Error opening callback connection:", err.Error())
+	} else {
+		callback = newCallback
+	}
+
 	// If we have a connection, try and register ourselves as a worker
 	err = server.Call(stubs.ServerConnectWorker,
-		stubs.WorkerConnectRequest{WorkerAddress: ourAddress}, response)
+		stubs.WorkerConnectRequest{WorkerAddress: ourAddress, Cores: runtime.NumCPU(), Callback: token}, response)
 	if err != nil {
 		println("Connection error", err.Error())
+		disconnectFromServer()
 		return false
 	} else if response.Success == false {
 		println("Server error", response.Message)
+		disconnectFromServer()
 		return false
 	}
 
@@ -133,44 +149,14 @@ func connectToServer() bool {
 	return true
 }
 
-// GAME LOGIC BELOW
-
-// Calculate the next turn, given pointers to the start and end to operate over
-// Return a fragment of the board with the next turn's cells
-func doTurn(halo stubs.Halo, threads int) (boardFragment stubs.Fragment) {
-	width := halo.BitBoard.RowLength
-	board := halo.BitBoard.Bytes.Decode()
-	height := halo.EndPtr - halo.StartPtr
-	newBoard := make([][]bool, height)
-
-	// Don't allow there to be more threads than rows
-	if threads > height {
-		threads = height
-	}
-	var wg sync.WaitGroup
-	// Split the board into threads
-	fragHeight := height / threads
-	for i := 0; i < threads; i++ {
-		// Calculate the bounds for this thread
-		start := i * fragHeight
-		end := (i + 1) * fragHeight
-		if i == threads-1 {
-			end = height
-		}
-		// Add this thread to the waitgroup
-		wg.Add(1)
-		// Iterate over each cell
-		go updateRegion(start, end, halo, newBoard, width, board, &wg)
-	}
-
-	// Wait for all threads to finish
-	wg.Wait()
-
-	// Create a fragment from the results of the threads
-	boardFragment = stubs.Fragment{
-		StartRow: halo.StartPtr,
-		EndRow:   halo.EndPtr,
-		BitBoard: stubs.BitBoardFromSlice(newBoard, halo.EndPtr-halo.StartPtr, width), // Create a new bitboard
+// Close our connections to the server, so we try connecting again on the next ping
+func disconnectFromServer() {
+	server.Close()
+	server = nil
+	if callback != nil {
+		callback.Close()
+		callback = nil
 	}
-	return boardFragment
 }
+
+// The game logic is in the kernel package, so the server can use it too
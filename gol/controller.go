@@ -28,7 +28,7 @@ type Controller struct {
 	params   Params
 	channels controllerChannels
 	state    stubs.State
-	previous [][]bool
+	previous [][]uint8
 
 	timeoutTimer  *time.Timer
 	lastAliveTurn int
@@ -47,6 +47,12 @@ func (c *Controller) GameStateChange(req stubs.StateChangeReport, res *stubs.Emp
 		NewState:       req.New,
 	}
 	c.state = req.New
+	// The server doesn't report alive cells while paused, so don't time out
+	if req.New == stubs.Paused {
+		c.timeoutTimer.Stop()
+	} else if req.New == stubs.Executing {
+		c.timeoutTimer.Reset(5 * time.Second)
+	}
 	if req.New == stubs.Quitting {
 		c.stopChan <- true
 	}
@@ -73,19 +79,22 @@ func (c *Controller) FinalTurnComplete(req stubs.BoardStateReport, res *stubs.Em
 // It contains a copy of the board on this turn so we can display it
 func (c *Controller) TurnComplete(req stubs.BoardStateReport, res *stubs.Empty) (err error) {
 	// Reset the timeout timer
-	c.timeoutTimer.Reset(5 * time.Second)
+	// Turns can be shown while paused (stepping through the history) but the timer stays stopped
+	if c.state != stubs.Paused {
+		c.timeoutTimer.Reset(5 * time.Second)
+	}
 
 	// If any cells have changed then send a cellflipped event
 	board := req.Board.ToSlice()
 	for row := 0; row < req.Board.NumRows; row++ {
 		for col := 0; col < req.Board.RowLength; col++ {
-			// If true send 1, else send 0
 			// If we have no previous board, send the 0th turn events
 			if c.previous == nil {
-				if board[row][col] == true {
+				if board[row][col] != 0 {
 					c.channels.events <- CellFlipped{
 						CompletedTurns: req.CompletedTurns,
 						Cell:           util.Cell{X: col, Y: row},
+						State:          board[row][col],
 					}
 				}
 			} else if board[row][col] != c.previous[row][col] {
@@ -93,6 +102,7 @@ func (c *Controller) TurnComplete(req stubs.BoardStateReport, res *stubs.Empty)
 				c.channels.events <- CellFlipped{
 					CompletedTurns: req.CompletedTurns,
 					Cell:           util.Cell{X: col, Y: row},
+					State:          board[row][col],
 				}
 			}
 		}
@@ -127,6 +137,14 @@ func (c *Controller) ReportAliveCells(req stubs.AliveCellsReport, res *stubs.Emp
 	// Output turns / second
 	fmt.Printf("%.2f", float64(turnsDiff)/timeDiff.Seconds())
 	println(" turns/s")
+	// EXTENSION: output how much of the board the workers could skip
+	if tiles := req.Tiles.Computed + req.Tiles.Skipped; tiles > 0 {
+		fmt.Printf("%.1f%% of tiles skipped\n", 100*float64(req.Tiles.Skipped)/float64(tiles))
+	}
+	// EXTENSION: output how many fragments had to be sent to a second worker
+	if req.Speculations > 0 {
+		fmt.Println(req.Speculations, "fragments sent to a second worker")
+	}
 
 	c.lastAliveTime = now
 	c.lastAliveTurn = req.CompletedTurns
@@ -136,15 +154,28 @@ func (c *Controller) ReportAliveCells(req stubs.AliveCellsReport, res *stubs.Emp
 	return
 }
 
+// CycleDetected is called by the server when the board becomes static or periodic
+// If we asked to stop on a cycle, the server will send the final turn shortly after
+func (c *Controller) CycleDetected(req stubs.CycleReport, res *stubs.Empty) (err error) {
+	println("Board repeats every", req.Period, "turns from turn", req.StartTurn)
+	// Send an event
+	c.channels.events <- CycleDetected{
+		CompletedTurns: req.CompletedTurns,
+		StartTurn:      req.StartTurn,
+		Period:         req.Period,
+	}
+	return
+}
+
 // The controller function sets up the controller to connect to the server
 // It will also start an RPC server and only returns when this is closed
 // When this function ends, it will cleanly close the events channel, signaling the program to halt
 func controller(p Params, c controllerChannels) {
 	// Create a new board to store 0th turn
-	board := make([][]bool, p.ImageHeight)
+	board := make([][]uint8, p.ImageHeight)
 	// Make a column array for each row
 	for row := 0; row < p.ImageHeight; row++ {
-		board[row] = make([]bool, p.ImageWidth)
+		board[row] = make([]uint8, p.ImageWidth)
 	}
 
 	if p.ResumeGame {
@@ -171,28 +202,33 @@ func controller(p Params, c controllerChannels) {
 
 		stopChan: make(chan bool),
 	}
-	controllerRPC := rpc.NewServer()
-	controllerRPC.Register(&controller)
+	if p.Offline {
+		// EXTENSION: play the game ourselves, this returns when the game has ended
+		runOffline(p, c, board, &controller)
+	} else {
+		controllerRPC := rpc.NewServer()
+		controllerRPC.Register(&controller)
 
-	// Start a listener to accept incoming RPC calls
-	listener, err := net.Listen("tcp", ":"+p.Port)
-	if err != nil {
-		println("Error starting listener:", err.Error())
-		return
-	}
+		// Start a listener to accept incoming RPC calls
+		listener, err := net.Listen("tcp", ":"+p.Port)
+		if err != nil {
+			println("Error starting listener:", err.Error())
+			return
+		}
 
-	// Start a goroutine to connect to the server and start a game
-	go runGame(p, c, board, controller, listener)
+		// Start a goroutine to connect to the server and start a game
+		go runGame(p, c, board, controller, controllerRPC, listener)
 
-	// Block this routiune and handle incoming RPC calls
-	// This will return when the listener is closed
-	controllerRPC.Accept(listener)
+		// Block this routiune and handle incoming RPC calls
+		// This will return when the listener is closed
+		controllerRPC.Accept(listener)
 
-	// At this point the game has ended
+		// At this point the game has ended
 
-	// Hold off so repeated tests don't cause issues with so many simultaneous connections
-	// We shouldn't have to do this but the RPC package has no way to gracefully shutdown
-	time.Sleep(400 * time.Millisecond)
+		// Hold off so repeated tests don't cause issues with so many simultaneous connections
+		// We shouldn't have to do this but the RPC package has no way to gracefully shutdown
+		time.Sleep(400 * time.Millisecond)
+	}
 	c.ioCommand <- ioCheckIdle
 	<-c.ioIdle
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
@@ -201,7 +237,7 @@ func controller(p Params, c controllerChannels) {
 
 // RunGame is responsible for connecting to the server and handling channels from the server
 // It will attempt to establish a connection, if this is successful it will then call ServerStartGame
-func runGame(p Params, c controllerChannels, board [][]bool, controller Controller, listener net.Listener) {
+func runGame(p Params, c controllerChannels, board [][]uint8, controller Controller, controllerRPC *rpc.Server, listener net.Listener) {
 	// When this function returns, close the listener
 	defer listener.Close()
 	// Attempt to connect to the server
@@ -227,24 +263,44 @@ func runGame(p Params, c controllerChannels, board [][]bool, controller Controll
 			return
 		}
 
+		// EXTENSION: open a connection for the server to call us back on, so it doesn't have to reach our address
+		// If this fails, the server can still dial us
+		callback, token, err := util.DialCallback(p.ServerAddress, controllerRPC)
+		if err != nil {
+			println("Error opening callback connection:", err.Error())
+		}
+
 		// Ask the server to start a game
 		// Pass all the information required to start (or continue) a game
 		err = server.Call(stubs.ServerStartGame, stubs.StartGameRequest{
-			ControllerAddress: p.OurIP + ":" + p.Port,
+			ControllerAddress: util.AdvertisedAddress(p.OurIP, p.Port, p.ServerAddress),
+			Callback:          token,
 			Height:            p.ImageHeight,
 			Width:             p.ImageWidth,
 			MaxTurns:          p.Turns,
 			Threads:           p.Threads,
-			Board:             stubs.BitBoardFromSlice(board, p.ImageHeight, p.ImageWidth),
+			Board:             stubs.StateBoardFromSlice(board, p.ImageHeight, p.ImageWidth, p.states()),
 			VisualUpdates:     p.VisualUpdates,
+			Rule:              p.Rule,
+			Topology:          p.Topology,
 			StartNew:          !p.ResumeGame,
+			SessionID:         p.Session,
+			StopOnCycle:       p.StopOnCycle,
 		}, response)
 
 		// No errors, we can start responding to channels
 		if err == nil && response.Success {
-			println("Game starting!")
+			println("Game starting in session", response.SessionID)
+			// Keep the callback connection open until the game ends
+			if callback != nil {
+				defer callback.Close()
+			}
 			break
 		}
+		// The server won't use this attempt's callback connection, so don't leave it open
+		if callback != nil {
+			callback.Close()
+		}
 
 		// Print any errors
 		if err != nil {
@@ -256,12 +312,15 @@ func runGame(p Params, c controllerChannels, board [][]bool, controller Controll
 		time.Sleep(500 * time.Millisecond)
 	}
 
+	// Keypresses need to go to the same session as our game
+	sessionID := response.SessionID
+
 	// Handle all keypresses and channel inputs until the game stops
 	for {
 		select {
 		case key := <-c.keypresses:
 			// Send any keypresses we receive from SDL to the server
-			err = server.Call(stubs.ServerRegisterKeypress, stubs.KeypressRequest{Key: key}, response)
+			err = server.Call(stubs.ServerRegisterKeypress, stubs.KeypressRequest{Key: key, SessionID: sessionID}, response)
 			if err != nil {
 				println("Error sending keypress to server:", err.Error())
 			}
@@ -281,8 +340,12 @@ func runGame(p Params, c controllerChannels, board [][]bool, controller Controll
 
 // Load a board slice from a file
 // This will properly prepare all the channels for reading
-func loadBoard(c controllerChannels, p Params, board [][]bool) {
-	filename := strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight)
+func loadBoard(c controllerChannels, p Params, board [][]uint8) {
+	filename := "images/" + strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight) + ".pgm"
+	// Load the input file instead if we have one
+	if p.Input != "" {
+		filename = p.Input
+	}
 	println("Reading in file", filename)
 
 	// Set the IO channels to prepare for reading
@@ -295,7 +358,7 @@ func loadBoard(c controllerChannels, p Params, board [][]bool) {
 
 // Save a board slice to the file
 // This will properly prepare all the channels for writing
-func saveBoard(board [][]bool, completedTurns int, p Params, c controllerChannels) {
+func saveBoard(board [][]uint8, completedTurns int, p Params, c controllerChannels) {
 	filename := strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight) + "x" + strconv.Itoa(completedTurns)
 	println("Saving to file", filename)
 
@@ -311,16 +374,11 @@ func saveBoard(board [][]bool, completedTurns int, p Params, c controllerChannel
 // Before this is run, two channels must be set:
 // ioCommand <- input
 // ioFilename <- "name"
-func boardFromFileInput(board [][]bool, height, width int, fileInput <-chan uint8, events chan<- Event) {
+func boardFromFileInput(board [][]uint8, height, width int, fileInput <-chan uint8, events chan<- Event) {
 	for row := 0; row < height; row++ {
 		for col := 0; col < width; col++ {
-			cell := <-fileInput
-			// Set the cell value to the corresponding image pixel
-			if cell == 0 {
-				board[row][col] = false
-			} else {
-				board[row][col] = true
-			}
+			// The io goroutine sends the state of each cell
+			board[row][col] = <-fileInput
 		}
 	}
 }
@@ -329,15 +387,11 @@ func boardFromFileInput(board [][]bool, height, width int, fileInput <-chan uint
 // Before this is run, two channels must be set:
 // ioCommand <- input
 // ioFilename <- "name"
-func boardToFileOutput(board [][]bool, height, width int, fileOutput chan<- uint8) {
+func boardToFileOutput(board [][]uint8, height, width int, fileOutput chan<- uint8) {
 	for row := 0; row < height; row++ {
 		for col := 0; col < width; col++ {
-			// If true send 1, else send 0
-			if board[row][col] {
-				fileOutput <- 1
-			} else {
-				fileOutput <- 0
-			}
+			// Send the state of the cell, the io goroutine decides how to write it
+			fileOutput <- board[row][col]
 		}
 	}
 }
@@ -40,9 +40,11 @@ type StateChange struct { // implements Event
 // CellFlipped is an Event notifying the GUI about a change of state of a single cell.
 // This even should be sent every time a cell changes state.
 // Make sure to send this event for all cells that are alive when the image is loaded in.
+// State is the new state of the cell: 0 is dead, 1 is alive and higher states are dying (Generations rules).
 type CellFlipped struct { // implements Event
 	CompletedTurns int
 	Cell           util.Cell
+	State          uint8
 }
 
 // TurnComplete is an Event notifying the GUI about turn completion.
@@ -60,6 +62,15 @@ type FinalTurnComplete struct {
 	Alive          []util.Cell
 }
 
+// CycleDetected is an Event notifying the user that the board has become static or periodic.
+// The board on StartTurn is the same as the board Period turns later, a Period of 1 is a still life.
+// This Event is sent once, when the cycle is first found.
+type CycleDetected struct { // implements Event
+	CompletedTurns int
+	StartTurn      int
+	Period         int
+}
+
 func (event StateChange) String() string {
 	return fmt.Sprintf("%v", event.NewState)
 }
@@ -108,6 +119,17 @@ func (event FinalTurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CycleDetected) String() string {
+	if event.Period == 1 {
+		return fmt.Sprintf("Still life from turn %v", event.StartTurn)
+	}
+	return fmt.Sprintf("Cycle of period %v from turn %v", event.Period, event.StartTurn)
+}
+
+func (event CycleDetected) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 // This might all seem like weird syntax to you...
 // You have however seen something similar to it before in first year.
 
@@ -3,6 +3,7 @@ package gol
 import (
 	"os"
 
+	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -14,9 +15,39 @@ type Params struct {
 	ImageHeight   int
 	ServerAddress string
 	Port          string
+	// OurIP is the address the server can dial us back on, either a host or a host and port
+	// If it has no port, Port is used
 	OurIP         string
 	VisualUpdates bool
 	ResumeGame    bool
+	Rule          string
+	Topology      string
+	// Session is the server session to resume, if empty the most recent one is resumed
+	Session string
+	// StopOnCycle ends the game early once the board is static or periodic
+	// The final board is still the board after Turns turns
+	StopOnCycle bool
+	// Input is the path of the board to load, if empty images/WxH.pgm is used
+	// If the width or height are 0 they are read from the file
+	// Pattern files (.rle, .cells or .lif) are placed on an empty board, PatternX and PatternY
+	// give the position of their top left corner, negative values centre it
+	Input    string
+	PatternX int
+	PatternY int
+	// OutputFormat is the format boards are saved in: "pgm" (the default), "rle", "cells" or "life106"
+	OutputFormat string
+	// OutputDir is the directory boards are saved in, "out" by default
+	OutputDir string
+	// Offline runs the whole game in this process, without a server or workers
+	// Games are also played offline if there is no server address
+	Offline bool
+}
+
+// states returns the number of cell states used by the rule
+// Invalid rules are rejected by the server, so treat them as two state here
+func (p Params) states() int {
+	rule, _ := stubs.ParseRule(p.Rule)
+	return rule.NumStates()
 }
 
 // Find the server address as an env variable
@@ -26,18 +57,52 @@ func getServerAddressFromEnvs() string {
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
-	// Get our public IP address
-	if p.OurIP == "" {
-		p.OurIP = util.GetPublicIP()
+	if p.ServerAddress == "" {
+		// If flags haven't been properly read (like in testing) then try and get the address from here
+		p.ServerAddress = getServerAddressFromEnvs()
+	}
+	// EXTENSION: without a server, play the game ourselves
+	if p.ServerAddress == "" && !p.Offline {
+		println("No server address, playing offline")
+		p.Offline = true
+	}
+	if p.Offline && p.ResumeGame {
+		println("Games can't be resumed offline, starting a new game")
+		p.ResumeGame = false
+	}
+	// Get our IP address, in case the server needs to dial us back
+	// EXTENSION: this is the address of the interface we reach the server with, rather than our public IP
+	if !p.Offline {
+		if p.OurIP == "" {
+			p.OurIP = util.GetOutboundIP(p.ServerAddress)
+		}
+		println("Our IP Address: ", p.OurIP)
 	}
-	println("Our IP Address: ", p.OurIP)
 	// If params doesn't have defaults for network connections, set them
 	if p.Port == "" {
 		p.Port = "8050"
 	}
-	if p.ServerAddress == "" {
-		// If flags haven't been properly read (like in testing) then try and get the address from here
-		p.ServerAddress = getServerAddressFromEnvs()
+	if p.OutputDir == "" {
+		p.OutputDir = "out"
+	}
+	// If we don't know the size of the board, get it from the input file
+	width, height, err := BoardSize(p)
+	if err != nil {
+		println("Error reading board size:", err.Error())
+		close(events)
+		return
+	}
+	p.ImageWidth, p.ImageHeight = width, height
+	if p.Rule == "" {
+		p.Rule = stubs.DefaultRule
+	}
+	// Make sure a pattern fits on the board before starting, so the io goroutine doesn't fail part way through reading it
+	if !p.ResumeGame {
+		if err := checkPattern(p); err != nil {
+			println("Error loading board:", err.Error())
+			close(events)
+			return
+		}
 	}
 
 	ioCommand := make(chan ioCommand)
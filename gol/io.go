@@ -1,11 +1,13 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"uk.ac.bris.cs/gameoflife/pattern"
+	"uk.ac.bris.cs/gameoflife/pgm"
+	"uk.ac.bris.cs/gameoflife/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -13,7 +15,7 @@ import (
 
 	Reading an image:
 	first, send an ioInput command down the command channel
-	next, send the filename down the filename channel
+	next, send the path of the file down the filename channel
 
 	the image is sent row by row down the input channel
 
@@ -31,6 +33,8 @@ type ioChannels struct {
 type ioState struct {
 	params   Params
 	channels ioChannels
+	// states is the number of cell states, used to map between states and grey levels
+	states int
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -49,22 +53,12 @@ const (
 
 // writePgmImage receives an array of bytes and writes it to a pgm file.
 func (io *ioState) writePgmImage(doWrite bool) {
-	_ = os.Mkdir("out", os.ModePerm)
+	_ = os.MkdirAll(io.params.OutputDir, os.ModePerm)
 
 	filename := <-io.channels.filename
-	file, ioError := os.Create("out/" + filename + ".pgm")
+	file, ioError := os.Create(filepath.Join(io.params.OutputDir, filename+".pgm"))
 	util.Check(ioError)
 	defer file.Close()
-	if doWrite {
-		_, _ = file.WriteString("P5\n")
-		//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-		_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-		_, _ = file.WriteString(" ")
-		_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-		_, _ = file.WriteString("\n")
-		_, _ = file.WriteString(strconv.Itoa(255))
-		_, _ = file.WriteString("\n")
-	}
 	world := make([][]byte, io.params.ImageHeight)
 	for i := range world {
 		world[i] = make([]byte, io.params.ImageWidth)
@@ -76,16 +70,21 @@ func (io *ioState) writePgmImage(doWrite bool) {
 			//if val != 0 {
 			//	fmt.Println(x, y)
 			//}
-			world[y][x] = val
+			// Store the grey level for this cell's state
+			world[y][x] = util.StateToGrey(val, io.states)
 		}
 	}
 	if doWrite {
+		image, ioError := pgm.NewWriter(file, "P5", io.params.ImageWidth, io.params.ImageHeight, 255)
+		util.Check(ioError)
 		for y := 0; y < io.params.ImageHeight; y++ {
 			for x := 0; x < io.params.ImageWidth; x++ {
-				_, ioError = file.Write([]byte{world[y][x]})
+				ioError = image.WritePixel(int(world[y][x]))
 				util.Check(ioError)
 			}
 		}
+		ioError = image.Close()
+		util.Check(ioError)
 	}
 	ioError = file.Sync()
 	util.Check(ioError)
@@ -93,46 +92,171 @@ func (io *ioState) writePgmImage(doWrite bool) {
 	fmt.Println("File", filename, "output done!")
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
-	filename := <-io.channels.filename
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
+// readPgmImage opens a pgm (or pbm) file and sends the state of each cell.
+func (io *ioState) readPgmImage(filename string) {
+	file, ioError := os.Open(filename)
 	util.Check(ioError)
+	defer file.Close()
 
-	fields := strings.Fields(string(data))
-
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
-	}
+	image, ioError := pgm.NewReader(file)
+	util.Check(ioError)
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
+	if image.Width != io.params.ImageWidth {
 		panic("Incorrect width")
 	}
-
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
+	if image.Height != io.params.ImageHeight {
 		panic("Incorrect height")
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	for i := 0; i < image.Width*image.Height; i++ {
+		v, ioError := image.ReadPixel()
+		util.Check(ioError)
+		// Send the cell state this grey level represents
+		io.channels.input <- util.GreyToState(image.Grey(v), io.states)
+	}
+
+	fmt.Println("File", filename, "input done!")
+}
+
+// readPattern opens a pattern file (rle, cells or life 1.06), places it on an empty board
+// and sends the state of every cell on the board.
+func (io *ioState) readPattern(filename string) {
+	p, ioError := pattern.ReadFile(filename)
+	util.Check(ioError)
+	// Warn if the pattern was made for a different rule
+	patternRule, ruleError := stubs.ParseRule(p.Rule)
+	playing, _ := stubs.ParseRule(io.params.Rule)
+	if p.Rule != "" && (ruleError != nil || patternRule != playing) {
+		fmt.Println("Pattern", filename, "is for rule", p.Rule, "but playing", io.params.Rule)
 	}
 
-	image := []byte(fields[4])
-	for _, b := range image {
-		io.channels.input <- b
+	// Put the pattern where it was asked for, or where the file says, or in the centre
+	// Run has already checked it fits with checkPattern
+	board, ioError := pattern.Place(p, io.params.ImageWidth, io.params.ImageHeight, io.params.PatternX, io.params.PatternY)
+	util.Check(ioError)
+
+	for row := 0; row < io.params.ImageHeight; row++ {
+		for col := 0; col < io.params.ImageWidth; col++ {
+			io.channels.input <- board[row][col]
+		}
 	}
 
 	fmt.Println("File", filename, "input done!")
 }
 
+// writePatternImage receives the state of every cell and writes them to a pattern file
+// in the given format (rle, cells or life106).
+func (io *ioState) writePatternImage(format string) {
+	_ = os.MkdirAll(io.params.OutputDir, os.ModePerm)
+
+	filename := <-io.channels.filename
+	file, ioError := os.Create(filepath.Join(io.params.OutputDir, filename+pattern.Extensions[format]))
+	util.Check(ioError)
+	defer file.Close()
+
+	world := make([][]uint8, io.params.ImageHeight)
+	for y := range world {
+		world[y] = make([]uint8, io.params.ImageWidth)
+		for x := range world[y] {
+			world[y][x] = <-io.channels.output
+		}
+	}
+
+	switch format {
+	case "rle":
+		// RLE files include the rule line
+		rule, _ := stubs.ParseRule(io.params.Rule)
+		ioError = pattern.WriteRLE(file, world, rule.String(), io.states)
+	case "cells":
+		ioError = pattern.WriteCells(file, world, filename)
+	case "life106":
+		ioError = pattern.WriteLife106(file, world)
+	}
+	util.Check(ioError)
+	ioError = file.Sync()
+	util.Check(ioError)
+
+	fmt.Println("File", filename, "output done!")
+}
+
+// DefaultBoardSize is the width and height of the board when there is no input file to get them from
+const DefaultBoardSize = 512
+
+// BoardSize works out the width and height of the board, for any of them that are 0 in the params
+// They are read from the input file, or are DefaultBoardSize if there isn't one
+func BoardSize(p Params) (width, height int, err error) {
+	width, height = p.ImageWidth, p.ImageHeight
+	if width != 0 && height != 0 {
+		return width, height, nil
+	}
+	inputWidth, inputHeight := DefaultBoardSize, DefaultBoardSize
+	if p.Input != "" {
+		inputWidth, inputHeight, err = ReadBoardSize(p.Input)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if width == 0 {
+		width = inputWidth
+	}
+	if height == 0 {
+		height = inputHeight
+	}
+	return width, height, nil
+}
+
+// ReadBoardSize reads the width and height of a board from a pgm image or pattern file
+// without loading the whole board. An empty path gives an error.
+func ReadBoardSize(path string) (width, height int, err error) {
+	if path == "" {
+		return 0, 0, errors.New("no input file to read the board size from")
+	}
+	if pattern.IsPatternFile(path) {
+		p, err := pattern.ReadFile(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		// Positioned patterns need room for the space above and to the left of them
+		return p.X + p.Width, p.Y + p.Height, nil
+	}
+
+	// Read the size from the pgm header
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	image, err := pgm.NewReader(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return image.Width, image.Height, nil
+}
+
+// checkPattern checks a pattern input file can be read, only has states the rule has,
+// and fits on the board where it is asked to go
+// Other inputs aren't checked, so nil is returned for them
+func checkPattern(p Params) error {
+	if !pattern.IsPatternFile(p.Input) {
+		return nil
+	}
+	pat, err := pattern.ReadFile(p.Input)
+	if err != nil {
+		return err
+	}
+	if err := pattern.CheckStates(pat, p.states()); err != nil {
+		return err
+	}
+	_, err = pattern.Place(pat, p.ImageWidth, p.ImageHeight, p.PatternX, p.PatternY)
+	return err
+}
+
 // startIo should be the entrypoint of the io goroutine.
 func startIo(p Params, c ioChannels) {
 	io := ioState{
 		params:   p,
 		channels: c,
+		states:   p.states(),
 	}
 
 	for {
@@ -140,9 +264,19 @@ func startIo(p Params, c ioChannels) {
 		case command := <-io.channels.command:
 			switch command {
 			case ioInput:
-				io.readPgmImage()
+				// Patterns are placed on an empty board, anything else is a pgm image
+				filename := <-io.channels.filename
+				if pattern.IsPatternFile(filename) {
+					io.readPattern(filename)
+				} else {
+					io.readPgmImage(filename)
+				}
 			case ioOutput:
-				io.writePgmImage(true)
+				if _, isPattern := pattern.Extensions[io.params.OutputFormat]; isPattern {
+					io.writePatternImage(io.params.OutputFormat)
+				} else {
+					io.writePgmImage(true)
+				}
 			case ioCheckIdle:
 				io.channels.idle <- true
 			}
@@ -0,0 +1,152 @@
+package gol
+
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/kernel"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+/////////
+
+// EXTENSION: offline mode
+// The whole game is run in this process, with a goroutine for each thread instead of a server and workers
+// The game reports to the controller's RPC methods directly, the same way the server does over the network,
+// so the events are the same as when playing online
+// Cycles are found the same way as on the server, so the game can stop early on one, and a paused game can be
+// stepped forward a turn, but there is no history to step back through and running a number of turns is only over RPC
+
+/////////
+
+// offlineCycleWindow is the longest cycle found offline, the same as the server's default
+const offlineCycleWindow = 1024
+
+// Run the game offline, reporting to the controller until the final turn is completed or we quit
+func runOffline(p Params, c controllerChannels, board [][]uint8, controller *Controller) {
+	rule, err := stubs.ParseRule(p.Rule)
+	if err != nil {
+		println("Invalid rule:", p.Rule)
+		return
+	}
+	topology, err := stubs.ParseTopology(p.Topology)
+	if err != nil {
+		println("Invalid topology:", p.Topology)
+		return
+	}
+	threads := p.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	// There is no server to time out on
+	controller.timeoutTimer.Stop()
+	// Nothing is waiting to receive the stop signal, so don't block on it
+	controller.stopChan = make(chan bool, 1)
+
+	height, width, states := p.ImageHeight, p.ImageWidth, rule.NumStates()
+	report := func(turn int) stubs.BoardStateReport {
+		return stubs.BoardStateReport{CompletedTurns: turn, Board: stubs.StateBoardFromSlice(board, height, width, states)}
+	}
+
+	// Look for cycles from the first turn, stopping early on one if we were asked to
+	cycles := util.NewCycleDetector(offlineCycleWindow)
+	cycleFound := false
+	stopAt := -1
+	checkCycle := func(turn int) {
+		if cycleFound {
+			return
+		}
+		start, period, found := cycles.Add(turn, util.HashBoard(board))
+		if !found {
+			return
+		}
+		cycleFound = true
+		controller.CycleDetected(stubs.CycleReport{CompletedTurns: turn, StartTurn: start, Period: period}, &stubs.Empty{})
+		if p.StopOnCycle {
+			// The board on the final turn is the same as the board a whole number of periods before it
+			stopAt = turn + (p.Turns-turn)%period
+			println("Stopping early on turn", stopAt)
+		}
+	}
+	checkCycle(0)
+
+	// If we want visual updates, show the first turn
+	if p.VisualUpdates {
+		controller.TurnComplete(report(0), &stubs.Empty{})
+	}
+
+	turn := 0
+	nextTurn := func() {
+		// Work out the whole board as one halo, split between the threads
+		halo := kernel.MakeHalo(0, height, height, width, board, topology, rule)
+		frag, _ := kernel.DoTurn(halo, threads, rule, stubs.TileMask{})
+		board = frag.Board.ToSlice()
+		// Turns are numbered the same as the server numbers them
+		if p.VisualUpdates {
+			controller.TurnComplete(report(turn), &stubs.Empty{})
+		}
+		turn++
+		checkCycle(turn)
+	}
+
+	// This ticker signals us to report the cells alive every 2 seconds
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	// Like the server, a turn is computed whenever there is nothing else to do, unless we are paused
+	running := make(chan struct{})
+	close(running)
+
+	for turn < p.Turns && turn != stopAt {
+		next := running
+		if controller.state == stubs.Paused {
+			next = nil
+		}
+		select {
+		case key := <-c.keypresses:
+			switch key {
+			case 's':
+				saveBoard(board, turn, p, c)
+			case 'q':
+				controller.GameStateChange(stubs.StateChangeReport{Previous: controller.state, New: stubs.Quitting, CompletedTurns: turn}, &stubs.Empty{})
+				return
+			case 'k':
+				// There is nothing else to shut down, so just finish with the board we have
+				finishOffline(report(turn), p, c)
+				return
+			case 'p':
+				next := stubs.Paused
+				if controller.state == stubs.Paused {
+					next = stubs.Executing
+				}
+				controller.GameStateChange(stubs.StateChangeReport{Previous: controller.state, New: next, CompletedTurns: turn}, &stubs.Empty{})
+			case 'n':
+				// Step forward a single turn while paused
+				if controller.state == stubs.Paused {
+					nextTurn()
+				}
+			default:
+				println("Key", string(key), "isn't available offline")
+			}
+		case <-ticker.C:
+			if controller.state != stubs.Paused {
+				controller.ReportAliveCells(stubs.AliveCellsReport{CompletedTurns: turn, NumAlive: len(util.GetAliveCells(board))}, &stubs.Empty{})
+			}
+		case <-next:
+			nextTurn()
+		}
+	}
+	// If the game stopped early on a cycle, the board is the same as it would be on the final turn
+	finishOffline(report(p.Turns), p, c)
+}
+
+// Send the final board and save it
+// Unlike online, the board is saved before returning, so it is always written before the events channel is closed
+func finishOffline(final stubs.BoardStateReport, p Params, c controllerChannels) {
+	println("Final turn complete")
+	cells := final.Board.ToSlice()
+	c.events <- FinalTurnComplete{
+		CompletedTurns: final.CompletedTurns,
+		Alive:          util.GetAliveCells(cells),
+	}
+	saveBoard(cells, final.CompletedTurns, p, c)
+}
@@ -0,0 +1,173 @@
+// Package hashlife runs the Game of Life with the HashLife algorithm
+// The board is stored as a quadtree, and every square of cells that appears more than once is only stored once.
+// The future of each square is remembered, so boards with a lot of repetition can jump thousands of turns at once.
+package hashlife
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// maxNodes is how many nodes are kept before the node table is cleared out to save memory
+const maxNodes = 1 << 21
+
+// Universe is a board on a torus, stored as a quadtree of nodes
+// A width by height board is repeated to fill a square torus with a power of two side,
+// which has the same cells turn after turn
+type Universe struct {
+	width  int
+	height int
+	rule   stubs.Rule
+
+	root  *node
+	nodes map[quadrants]*node
+	alive *node
+	dead  *node
+}
+
+// Supported returns true if a board can be run with HashLife
+// The board must be a torus with power of two sides, and the rule must have two states
+func Supported(height, width int, rule stubs.Rule, topology stubs.Topology) bool {
+	return topology == stubs.Torus && rule.NumStates() == 2 && isPowerOfTwo(width) && isPowerOfTwo(height)
+}
+
+// isPowerOfTwo returns true if n is a power of two, and at least 2
+func isPowerOfTwo(n int) bool {
+	return n >= 2 && n&(n-1) == 0
+}
+
+// New makes a universe from a board, which must be supported (see Supported)
+func New(board [][]uint8, height, width int, rule stubs.Rule) *Universe {
+	u := &Universe{
+		width:  width,
+		height: height,
+		rule:   rule,
+		alive:  &node{alive: true, population: 1},
+		dead:   &node{},
+	}
+	u.nodes = make(map[quadrants]*node)
+	size := width
+	if height > size {
+		size = height
+	}
+	level := uint(0)
+	for 1<<level < size {
+		level++
+	}
+	u.root = u.build(board, 0, 0, level)
+	return u
+}
+
+// Make the node for a square of the board, repeating the board if the square goes past its edges
+func (u *Universe) build(board [][]uint8, x, y int, level uint) *node {
+	if level == 0 {
+		return u.cell(board[y%u.height][x%u.width] == 1)
+	}
+	half := 1 << (level - 1)
+	return u.join(
+		u.build(board, x, y, level-1),
+		u.build(board, x+half, y, level-1),
+		u.build(board, x, y+half, level-1),
+		u.build(board, x+half, y+half, level-1),
+	)
+}
+
+// Step moves the universe on a number of turns
+// Jumps are made in powers of two, so a large number of turns only takes a few jumps
+func (u *Universe) Step(turns int) {
+	for turns > 0 {
+		// Jump the largest power of two we can
+		j := uint(0)
+		for 2<<j <= turns {
+			j++
+		}
+		u.jump(j)
+		turns -= 1 << j
+
+		if len(u.nodes) > maxNodes {
+			u.collect()
+		}
+	}
+}
+
+// Move the universe on 2^j turns
+func (u *Universe) jump(j uint) {
+	// Fill a square with copies of the torus, big enough that its middle after 2^j turns is known
+	// The torus is periodic, so the middle is copies of the torus again
+	level := u.root.level
+	tiles := u.root
+	for tiles.level < level+1 || tiles.level < j+2 {
+		tiles = u.join(tiles, tiles, tiles, tiles)
+	}
+	result := u.successor(tiles, j)
+	if result.level == level {
+		// The middle is half a torus out, so swap the quadrants of the result back round
+		u.root = u.join(result.se, result.sw, result.ne, result.nw)
+		return
+	}
+	// Otherwise the middle lines up with the copies of the torus, so any one of them will do
+	for result.level > level {
+		result = result.nw
+	}
+	u.root = result
+}
+
+// Clear out the node table, keeping only the nodes in the current board
+// The remembered results are forgotten, so they will have to be worked out again
+func (u *Universe) collect() {
+	u.nodes = make(map[quadrants]*node)
+	copies := make(map[*node]*node)
+	var copyNode func(n *node) *node
+	copyNode = func(n *node) *node {
+		if n.level == 0 {
+			return n
+		}
+		if c, ok := copies[n]; ok {
+			return c
+		}
+		c := u.join(copyNode(n.nw), copyNode(n.ne), copyNode(n.sw), copyNode(n.se))
+		copies[n] = c
+		return c
+	}
+	u.root = copyNode(u.root)
+}
+
+// Population returns the number of alive cells
+func (u *Universe) Population() int {
+	// The board may have been repeated to fill the torus
+	size := 1 << u.root.level
+	return u.root.population / ((size / u.width) * (size / u.height))
+}
+
+// Fill copies the cells of the universe into a board
+func (u *Universe) Fill(board [][]uint8) {
+	u.fill(board, u.root, 0, 0)
+}
+
+// Copy the cells of a node into a board, skipping any that are past its edges
+func (u *Universe) fill(board [][]uint8, n *node, x, y int) {
+	if x >= u.width || y >= u.height {
+		return
+	}
+	if n.level == 0 {
+		if n.alive {
+			board[y][x] = 1
+		} else {
+			board[y][x] = 0
+		}
+		return
+	}
+	half := 1 << (n.level - 1)
+	if n.population == 0 {
+		// Empty squares can be cleared without going down to each cell
+		for row := y; row < y+2*half && row < u.height; row++ {
+			for col := x; col < x+2*half && col < u.width; col++ {
+				board[row][col] = 0
+			}
+		}
+		return
+	}
+	u.fill(board, n.nw, x, y)
+	u.fill(board, n.ne, x+half, y)
+	u.fill(board, n.sw, x, y+half)
+	u.fill(board, n.se, x+half, y+half)
+}
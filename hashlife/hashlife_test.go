@@ -0,0 +1,140 @@
+package hashlife
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// readBoard reads a board image into a slice of cells
+func readBoard(path string, height, width int) [][]uint8 {
+	board := make([][]uint8, height)
+	for row := range board {
+		board[row] = make([]uint8, width)
+	}
+	for _, cell := range util.ReadAliveCells(path, width, height) {
+		board[cell.Y][cell.X] = 1
+	}
+	return board
+}
+
+// step works out the next turn of a torus board one cell at a time
+func step(board [][]uint8, rule stubs.Rule) [][]uint8 {
+	height, width := len(board), len(board[0])
+	next := make([][]uint8, height)
+	for y := range board {
+		next[y] = make([]uint8, width)
+		for x := range board[y] {
+			neighbours := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && board[(y+dy+height)%height][(x+dx+width)%width] == 1 {
+						neighbours++
+					}
+				}
+			}
+			next[y][x] = rule.Next(board[y][x], neighbours)
+		}
+	}
+	return next
+}
+
+func assertBoard(t *testing.T, given, expected [][]uint8) {
+	for y := range expected {
+		for x := range expected[y] {
+			if given[y][x] != expected[y][x] {
+				t.Fatalf("cell (%v, %v): expected %v, got %v", x, y, expected[y][x], given[y][x])
+			}
+		}
+	}
+}
+
+// TestImages checks the boards in check/images are reached from the boards in images
+func TestImages(t *testing.T) {
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	for _, size := range []int{16, 64, 512} {
+		for _, turns := range []int{0, 1, 100} {
+			t.Run(fmt.Sprintf("%dx%dx%d", size, size, turns), func(t *testing.T) {
+				u := New(readBoard(fmt.Sprintf("../images/%vx%v.pgm", size, size), size, size), size, size, rule)
+				u.Step(turns)
+				board := make([][]uint8, size)
+				for row := range board {
+					board[row] = make([]uint8, size)
+				}
+				u.Fill(board)
+				assertBoard(t, board, readBoard(fmt.Sprintf("../check/images/%vx%vx%v.pgm", size, size, turns), size, size))
+			})
+		}
+	}
+}
+
+// TestSteps checks every turn of a random board matches working it out one cell at a time
+// The board isn't square, and the turns are jumped in different sized steps, some bigger than the board
+func TestSteps(t *testing.T) {
+	rule, _ := stubs.ParseRule("B36/S23")
+	height, width := 16, 32
+	board := make([][]uint8, height)
+	for row := range board {
+		board[row] = make([]uint8, width)
+		for col := range board[row] {
+			board[row][col] = uint8(rand.Intn(2))
+		}
+	}
+	for _, stepSize := range []int{1, 3, 8, 16, 21, 64, 100} {
+		t.Run(fmt.Sprintf("steps-of-%d", stepSize), func(t *testing.T) {
+			u := New(board, height, width, rule)
+			expected := board
+			filled := make([][]uint8, height)
+			for row := range filled {
+				filled[row] = make([]uint8, width)
+			}
+			for turn := 0; turn < 200; turn += stepSize {
+				u.Step(stepSize)
+				for i := 0; i < stepSize; i++ {
+					expected = step(expected, rule)
+				}
+				u.Fill(filled)
+				assertBoard(t, filled, expected)
+			}
+		})
+	}
+}
+
+// TestAliveCounts checks every 100th 512x512 alive count in check/alive, and that the board
+// still oscillates between 5565 and 5567 alive cells a hundred million turns later
+func TestAliveCounts(t *testing.T) {
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	f, err := os.Open("../check/alive/512x512.csv")
+	util.Check(err)
+	table, err := csv.NewReader(f).ReadAll()
+	util.Check(err)
+	f.Close()
+
+	u := New(readBoard("../images/512x512.pgm", 512, 512), 512, 512, rule)
+	turn := 0
+	for i := 100; i < len(table); i += 100 {
+		row := table[i]
+		completedTurns, _ := strconv.Atoi(row[0])
+		expected, _ := strconv.Atoi(row[1])
+		u.Step(completedTurns - turn)
+		turn = completedTurns
+		if u.Population() != expected {
+			t.Fatalf("At turn %v expected %v alive cells, got %v instead", turn, expected, u.Population())
+		}
+	}
+
+	u.Step(100000000 - turn)
+	if u.Population() != 5565 {
+		t.Fatalf("At turn 100000000 expected 5565 alive cells, got %v instead", u.Population())
+	}
+	u.Step(1)
+	if u.Population() != 5567 {
+		t.Fatalf("At turn 100000001 expected 5567 alive cells, got %v instead", u.Population())
+	}
+}
@@ -0,0 +1,156 @@
+package hashlife
+
+// node is a square of 2^level by 2^level cells, split into four quadrants
+// Nodes are never changed once made, and there is only ever one node for each arrangement of cells,
+// so nodes can be compared by pointer and the result of stepping one can be stored with it
+type node struct {
+	nw, ne, sw, se *node
+	level          uint
+	// alive is only used by level 0 nodes, which are single cells
+	alive      bool
+	population int
+	// results[j] is the centre of the node after 2^j generations, once it has been worked out
+	results []*node
+}
+
+// quadrants is used as the key of the node table
+type quadrants struct {
+	nw, ne, sw, se *node
+}
+
+// Get the node made of four quadrants, making it if it doesn't exist yet
+func (u *Universe) join(nw, ne, sw, se *node) *node {
+	key := quadrants{nw, ne, sw, se}
+	if n, ok := u.nodes[key]; ok {
+		return n
+	}
+	n := &node{
+		nw:         nw,
+		ne:         ne,
+		sw:         sw,
+		se:         se,
+		level:      nw.level + 1,
+		population: nw.population + ne.population + sw.population + se.population,
+	}
+	u.nodes[key] = n
+	return n
+}
+
+// Get the single cell node for a cell
+func (u *Universe) cell(alive bool) *node {
+	if alive {
+		return u.alive
+	}
+	return u.dead
+}
+
+// Get the middle half of a node, one level down
+func (u *Universe) centre(n *node) *node {
+	return u.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+// Get the node halfway between two side by side nodes, one level down
+func (u *Universe) centreHorizontal(w, e *node) *node {
+	return u.join(w.ne, e.nw, w.se, e.sw)
+}
+
+// Get the node halfway between two nodes above each other, one level down
+func (u *Universe) centreVertical(n, s *node) *node {
+	return u.join(n.sw, n.se, s.nw, s.ne)
+}
+
+// Work out the middle half of a node after 2^j generations, one level down
+// j can be at most level-2, since cells further out than that could affect the middle
+func (u *Universe) successor(n *node, j uint) *node {
+	if n.results == nil {
+		n.results = make([]*node, n.level-1)
+	}
+	if n.results[j] != nil {
+		return n.results[j]
+	}
+
+	var result *node
+	if n.level == 2 {
+		// A 4x4 node is small enough to work out by applying the rule to its middle 2x2 cells
+		result = u.join(u.nextCell(n, 1, 1), u.nextCell(n, 2, 1), u.nextCell(n, 1, 2), u.nextCell(n, 2, 2))
+	} else {
+		// Split the node into 9 overlapping nodes a level down
+		n00 := n.nw
+		n01 := u.centreHorizontal(n.nw, n.ne)
+		n02 := n.ne
+		n10 := u.centreVertical(n.nw, n.sw)
+		n11 := u.centre(n)
+		n12 := u.centreVertical(n.ne, n.se)
+		n20 := n.sw
+		n21 := u.centreHorizontal(n.sw, n.se)
+		n22 := n.se
+
+		if j == n.level-2 {
+			// Step each of the 9 nodes forward half the generations
+			// Then step the 4 nodes made from them forward the other half
+			half := j - 1
+			r00, r01, r02 := u.successor(n00, half), u.successor(n01, half), u.successor(n02, half)
+			r10, r11, r12 := u.successor(n10, half), u.successor(n11, half), u.successor(n12, half)
+			r20, r21, r22 := u.successor(n20, half), u.successor(n21, half), u.successor(n22, half)
+			result = u.join(
+				u.successor(u.join(r00, r01, r10, r11), half),
+				u.successor(u.join(r01, r02, r11, r12), half),
+				u.successor(u.join(r10, r11, r20, r21), half),
+				u.successor(u.join(r11, r12, r21, r22), half),
+			)
+		} else {
+			// Fewer generations are wanted, so take the centres of the 9 nodes without stepping them
+			// Then step the 4 nodes made from them forward all the generations
+			r00, r01, r02 := u.centre(n00), u.centre(n01), u.centre(n02)
+			r10, r11, r12 := u.centre(n10), u.centre(n11), u.centre(n12)
+			r20, r21, r22 := u.centre(n20), u.centre(n21), u.centre(n22)
+			result = u.join(
+				u.successor(u.join(r00, r01, r10, r11), j),
+				u.successor(u.join(r01, r02, r11, r12), j),
+				u.successor(u.join(r10, r11, r20, r21), j),
+				u.successor(u.join(r11, r12, r21, r22), j),
+			)
+		}
+	}
+	n.results[j] = result
+	return result
+}
+
+// Get whether a cell in a 4x4 node is alive
+func cellAlive(n *node, x, y int) bool {
+	quadrant := n.nw
+	switch {
+	case x >= 2 && y >= 2:
+		quadrant = n.se
+	case x >= 2:
+		quadrant = n.ne
+	case y >= 2:
+		quadrant = n.sw
+	}
+	x, y = x%2, y%2
+	switch {
+	case x == 1 && y == 1:
+		return quadrant.se.alive
+	case x == 1:
+		return quadrant.ne.alive
+	case y == 1:
+		return quadrant.sw.alive
+	}
+	return quadrant.nw.alive
+}
+
+// Apply the rule to a cell in the middle of a 4x4 node, returning its single cell node for the next generation
+func (u *Universe) nextCell(n *node, x, y int) *node {
+	neighbours := 0
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if (dx != 0 || dy != 0) && cellAlive(n, x+dx, y+dy) {
+				neighbours++
+			}
+		}
+	}
+	if cellAlive(n, x, y) {
+		return u.cell(u.rule.Survive&(1<<uint(neighbours)) != 0)
+	}
+	return u.cell(u.rule.Birth&(1<<uint(neighbours)) != 0)
+}
@@ -0,0 +1,90 @@
+package kernel
+
+import (
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// UpdateRegion calculates the next cell state for all cells within bounds, one cell at a time
+// The board is the halo's decoded bit planes, so this works for every rule and topology
+// Cells in tiles which aren't active are copied from the halo
+func UpdateRegion(start, end int, halo stubs.Halo, newBoard [][]uint8, width int, board [][]byte, edges [][]byte, rule stubs.Rule, active stubs.TileMask, wg *sync.WaitGroup) {
+	// Iterate through the region
+	for row := start; row < end; row++ {
+		newBoard[row] = make([]uint8, width)
+		for col := 0; col < width; col++ {
+			// EXTENSION: cells in inactive tiles can't change
+			if !active.IsSet(halo.StartPtr+row, col) {
+				newBoard[row][col] = stubs.GetStateCell(board, halo.Board.NumRows, halo.Board.RowLength, row+halo.Offset, col)
+				continue
+			}
+			// Apply game of life rules to this cell
+			newCell := nextCellState(col, row+halo.Offset, board, halo.Board.NumRows, halo.Board.RowLength, halo.Topology, edges, rule)
+			// Save the result in the new board
+			newBoard[row][col] = newCell
+		}
+	}
+	wg.Done()
+}
+
+// Calculate the next cell state according to the rule being played
+// Returns the next state of the cell
+func nextCellState(x int, y int, board [][]byte, bHeight, bWidth int, topology stubs.Topology, edges [][]byte, rule stubs.Rule) uint8 {
+	// Count the number of adjacent alive cells
+	adj := countAliveNeighbours(x, y, board, bHeight, bWidth, topology, edges)
+
+	// Let the rule decide if the cell is born, survives or dies
+	state := stubs.GetStateCell(board, bHeight, bWidth, y, x)
+	return rule.Next(state, adj)
+}
+
+// Count how many alive neighbours a cell has
+// Dying cells (in Generations rules) don't count as alive
+// The halo already contains the rows above and below, so only the left and right edges
+// need handling here, according to the board's topology
+func countAliveNeighbours(x int, y int, board [][]byte, height, width int, topology stubs.Topology, edges [][]byte) int {
+	numNeighbours := 0
+
+	// Count all alive cells in the board in a
+	// 1 cell radius of the centre
+	for _x := -1; _x < 2; _x++ {
+		for _y := -1; _y < 2; _y++ {
+			// Ignore the centre cell
+			if _x == 0 && _y == 0 {
+				continue
+			}
+
+			nx := x + _x
+			ny := y + _y
+			if nx < 0 || nx >= width {
+				switch topology {
+				case stubs.Plane:
+					// Off the edge of a plane, the cell is always dead
+					continue
+				case stubs.CrossSurface:
+					// The cell is in a mirrored row, the server sends these as edges
+					side := 0
+					if nx >= width {
+						side = 1
+					}
+					if stubs.IsAliveCell(edges, height, 2, ny, side) {
+						numNeighbours++
+					}
+					continue
+				default:
+					//wrap left<->right
+					nx = (nx + width) % width
+				}
+			}
+
+			// test if this cell is alive
+			v := stubs.IsAliveCell(board, height, width, ny, nx)
+			if v == true {
+				numNeighbours++
+			}
+		}
+	}
+
+	return numNeighbours
+}
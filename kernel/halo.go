@@ -0,0 +1,74 @@
+package kernel
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// MakeHalo creates a "halo" of cells containing only the cells required to calculat the next turn
+// Take the whole board and return a halo which can be passed to a worker to calculate rows start to end
+func MakeHalo(start, end int, height, width int, board [][]uint8, topology stubs.Topology, rule stubs.Rule) stubs.Halo {
+	// This will hold all the cells that will be stored in  the halo
+	cells := make([][]uint8, 0)
+
+	// Add the row above the boundary this worker calculates for
+	// At the edge of the board this follows the topology, so it may be wrapped, mirrored or dead
+	cells = append(cells, HaloRow(start-1, height, width, board, topology))
+	// Add rows we want to calculate the next turn of
+	for row := start; row < end; row++ {
+		cells = append(cells, board[row])
+	}
+	// Add the row below the boundary
+	cells = append(cells, HaloRow(end, height, width, board, topology))
+
+	// Return a new halo for these cells
+	halo := stubs.Halo{
+		Board:    stubs.StateBoardFromSlice(cells, len(cells), width, rule.NumStates()), // Convert the grid into a stateboard
+		Offset:   1,
+		StartPtr: start,
+		EndPtr:   end,
+		Topology: topology,
+	}
+
+	// On a cross-surface the cells off the left and right edges come from the mirrored row,
+	// which the worker won't have, so send them alongside the halo
+	if topology == stubs.CrossSurface {
+		halo.Edges = HaloEdges(start, end, height, width, board, topology, rule)
+	}
+	return halo
+}
+
+// HaloEdges gets the cells just off the left and right of rows start-1 to end, for a halo of rows start to end
+func HaloEdges(start, end int, height, width int, board [][]uint8, topology stubs.Topology, rule stubs.Rule) *stubs.StateBoard {
+	edges := make([][]uint8, end-start+2)
+	for i := range edges {
+		row := start - 1 + i
+		edges[i] = []uint8{
+			CellAt(-1, row, height, width, board, topology),
+			CellAt(width, row, height, width, board, topology),
+		}
+	}
+	return stubs.StateBoardFromSlice(edges, len(edges), 2, rule.NumStates())
+}
+
+// HaloRow gets a row of the board which may be just off the top or bottom edge
+func HaloRow(row int, height, width int, board [][]uint8, topology stubs.Topology) []uint8 {
+	// Rows on the board can be used as they are
+	if row >= 0 && row < height {
+		return board[row]
+	}
+	cells := make([]uint8, width)
+	for col := 0; col < width; col++ {
+		cells[col] = CellAt(col, row, height, width, board, topology)
+	}
+	return cells
+}
+
+// CellAt gets the value of a cell which may be just off the edge of the board
+// Cells which the topology says are off the board are always dead
+func CellAt(x, y int, height, width int, board [][]uint8, topology stubs.Topology) uint8 {
+	x, y, onBoard := topology.Wrap(x, y, width, height)
+	if !onBoard {
+		return 0
+	}
+	return board[y][x]
+}
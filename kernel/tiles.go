@@ -0,0 +1,31 @@
+package kernel
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// ChangedRows finds the tiles with a word that changed between the halo's packed rows and the new rows
+func ChangedRows(halo stubs.Halo, newRows [][]uint64, rows [][]uint64) stubs.TileMask {
+	changed := stubs.NewTileMask(halo.StartPtr, halo.EndPtr, halo.Board.RowLength)
+	for row := range newRows {
+		for k := range newRows[row] {
+			if newRows[row][k] != rows[row+halo.Offset][k] {
+				changed.Set(halo.StartPtr+row, k*64)
+			}
+		}
+	}
+	return changed
+}
+
+// ChangedCells finds the tiles with a cell that changed between the halo's decoded planes and the new board
+func ChangedCells(halo stubs.Halo, newBoard [][]uint8, board [][]byte) stubs.TileMask {
+	changed := stubs.NewTileMask(halo.StartPtr, halo.EndPtr, halo.Board.RowLength)
+	for row := range newBoard {
+		for col := range newBoard[row] {
+			if newBoard[row][col] != stubs.GetStateCell(board, halo.Board.NumRows, halo.Board.RowLength, row+halo.Offset, col) {
+				changed.Set(halo.StartPtr+row, col)
+			}
+		}
+	}
+	return changed
+}
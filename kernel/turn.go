@@ -0,0 +1,83 @@
+package kernel
+
+import (
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// DoTurn calculates the next turn of a halo, given pointers to the start and end to operate over
+// It is used by the workers, and by the server when it has no workers
+// Return a fragment of the board with the next turn's cells
+func DoTurn(halo stubs.Halo, threads int, rule stubs.Rule, active stubs.TileMask) (boardFragment stubs.Fragment, changed stubs.TileMask) {
+	width := halo.Board.RowLength
+	// Edges are only sent for some topologies
+	var edges [][]byte
+	if halo.Edges != nil {
+		edges = halo.Edges.Decode()
+	}
+	height := halo.EndPtr - halo.StartPtr
+
+	// EXTENSION: two state rules are worked out 64 cells at a time with the bit-parallel kernel
+	// Generations rules have dying states, so they are worked out one cell at a time
+	useWords := rule.NumStates() == 2
+	var board [][]byte
+	var rows [][]uint64
+	if useWords {
+		rows = halo.Board.Planes[0].DecodeRows(halo.Board.NumRows, width)
+	} else {
+		board = halo.Board.Decode()
+	}
+	newBoard := make([][]uint8, height)
+	newRows := make([][]uint64, height)
+
+	SplitRows(height, threads, func(start, end int, wg *sync.WaitGroup) {
+		if useWords {
+			UpdateRows(start, end, halo, newRows, rows, edges, rule, active, wg)
+		} else {
+			// Iterate over each cell
+			UpdateRegion(start, end, halo, newBoard, width, board, edges, rule, active, wg)
+		}
+	})
+
+	// Create a fragment from the results of the threads
+	boardFragment = stubs.Fragment{
+		StartRow: halo.StartPtr,
+		EndRow:   halo.EndPtr,
+	}
+	// Create a new stateboard, and find which tiles changed so the server knows what to work out next turn
+	if useWords {
+		boardFragment.Board = stubs.StateBoardFromRows(newRows, height, width)
+		changed = ChangedRows(halo, newRows, rows)
+	} else {
+		boardFragment.Board = stubs.StateBoardFromSlice(newBoard, height, width, rule.NumStates())
+		changed = ChangedCells(halo, newBoard, board)
+	}
+	return
+}
+
+// SplitRows splits rows 0 to height between threads, running update on each thread's rows
+// Returns once every thread has finished
+func SplitRows(height, threads int, update func(start, end int, wg *sync.WaitGroup)) {
+	// Don't allow there to be more threads than rows
+	if threads > height {
+		threads = height
+	}
+	var wg sync.WaitGroup
+	// Split the board into threads
+	fragHeight := height / threads
+	for i := 0; i < threads; i++ {
+		// Calculate the bounds for this thread
+		start := i * fragHeight
+		end := (i + 1) * fragHeight
+		if i == threads-1 {
+			end = height
+		}
+		// Add this thread to the waitgroup
+		wg.Add(1)
+		go update(start, end, &wg)
+	}
+
+	// Wait for all threads to finish
+	wg.Wait()
+}
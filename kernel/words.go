@@ -0,0 +1,150 @@
+package kernel
+
+import (
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// EXTENSION: bit-parallel kernel
+// Rows are packed into 64 bit words (see stubs.DecodeRows), and the next turn of a whole word of cells
+// is worked out at once with bitwise operations
+// Each cell's neighbour count is held across four words (a bit slice), one for each bit of the count
+
+// UpdateRows calculates the next cell state for all cells within bounds, 64 cells at a time
+// rows is the halo's packed rows, and only two state rules are supported
+// Words in tiles which aren't active are copied from the halo
+func UpdateRows(start, end int, halo stubs.Halo, newRows [][]uint64, rows [][]uint64, edges [][]byte, rule stubs.Rule, active stubs.TileMask, wg *sync.WaitGroup) {
+	width := halo.Board.RowLength
+	words := stubs.WordsPerRow(width)
+	// Bits past the end of the row must stay 0
+	lastMask := ^uint64(0) >> uint(words*64-width)
+	// Find which neighbour counts give birth and survival
+	births, survivals := ruleCounts(rule)
+
+	for row := start; row < end; row++ {
+		y := row + halo.Offset
+		above, here, below := rows[y-1], rows[y], rows[y+1]
+		// The cells just off the left and right of each row, according to the topology
+		aboveLeft, aboveRight := edgeBits(above, y-1, halo, edges)
+		hereLeft, hereRight := edgeBits(here, y, halo, edges)
+		belowLeft, belowRight := edgeBits(below, y+1, halo, edges)
+
+		newRows[row] = make([]uint64, words)
+		for k := 0; k < words; k++ {
+			// EXTENSION: words in inactive tiles can't change
+			if !active.IsSet(halo.StartPtr+row, k*64) {
+				newRows[row][k] = here[k]
+				continue
+			}
+			// Get each neighbour of the cells in this word, lined up with the cells
+			aw, a, ae := shifted(above, k, width, aboveLeft, aboveRight)
+			w, alive, e := shifted(here, k, width, hereLeft, hereRight)
+			bw, b, be := shifted(below, k, width, belowLeft, belowRight)
+
+			// Add up the neighbours with full adders
+			// Each adder adds three bits from every cell at once, giving a sum bit and a carry bit
+			s1, c1 := fullAdd(aw, a, ae)
+			s2, c2 := w^e, w&e
+			s3, c3 := fullAdd(bw, b, be)
+			ones, c4 := fullAdd(s1, s2, s3)
+			// The carries are worth 2 each
+			t1, c5 := fullAdd(c1, c2, c3)
+			twos, c6 := t1^c4, t1&c4
+			// These carries are worth 4 each, and there can only be 8 neighbours
+			fours, eights := c5^c6, c5&c6
+
+			// Apply the rule to every cell in the word
+			born, survived := uint64(0), uint64(0)
+			for _, n := range births {
+				born |= countEquals(n, ones, twos, fours, eights)
+			}
+			for _, n := range survivals {
+				survived |= countEquals(n, ones, twos, fours, eights)
+			}
+			next := (^alive & born) | (alive & survived)
+			if k == words-1 {
+				next &= lastMask
+			}
+			newRows[row][k] = next
+		}
+	}
+	wg.Done()
+}
+
+// Add three words bit by bit, returning the sum bits and the carry bits
+func fullAdd(a, b, c uint64) (sum, carry uint64) {
+	t := a ^ b
+	return t ^ c, (a & b) | (t & c)
+}
+
+// Get a word with a bit set for every cell whose neighbour count is n
+func countEquals(n uint, ones, twos, fours, eights uint64) uint64 {
+	mask := ^uint64(0)
+	for bit, slice := range [4]uint64{ones, twos, fours, eights} {
+		if n&(1<<uint(bit)) != 0 {
+			mask &= slice
+		} else {
+			mask &^= slice
+		}
+	}
+	return mask
+}
+
+// Get the neighbour counts that give birth and survival under a rule
+func ruleCounts(rule stubs.Rule) (births, survivals []uint) {
+	for n := uint(0); n <= 8; n++ {
+		if rule.Birth&(1<<n) != 0 {
+			births = append(births, n)
+		}
+		if rule.Survive&(1<<n) != 0 {
+			survivals = append(survivals, n)
+		}
+	}
+	return
+}
+
+// Get word k of a row, along with the words of its west and east neighbours
+// left and right are the cells just off the ends of the row
+func shifted(row []uint64, k int, width int, left, right uint64) (west, centre, east uint64) {
+	words := len(row)
+	centre = row[k]
+	// Cell c's west neighbour is cell c-1, so shift the cells up a bit
+	west = centre << 1
+	if k == 0 {
+		west |= left
+	} else {
+		west |= row[k-1] >> 63
+	}
+	// Cell c's east neighbour is cell c+1, so shift the cells down a bit
+	east = centre >> 1
+	if k == words-1 {
+		east |= right << uint((width-1)%64)
+	} else {
+		east |= row[k+1] << 63
+	}
+	return
+}
+
+// Get the cells just off the left and right of a halo row, according to the topology
+func edgeBits(row []uint64, y int, halo stubs.Halo, edges [][]byte) (left, right uint64) {
+	width := halo.Board.RowLength
+	switch halo.Topology {
+	case stubs.Plane:
+		// Off the edge of a plane, the cell is always dead
+		return 0, 0
+	case stubs.CrossSurface:
+		// The cell is in a mirrored row, the server sends these as edges
+		if stubs.IsAliveCell(edges, halo.Board.NumRows, 2, y, 0) {
+			left = 1
+		}
+		if stubs.IsAliveCell(edges, halo.Board.NumRows, 2, y, 1) {
+			right = 1
+		}
+		return
+	}
+	// Otherwise wrap left<->right
+	left = (row[(width-1)/64] >> uint((width-1)%64)) & 1
+	right = row[0] & 1
+	return
+}
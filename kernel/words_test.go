@@ -0,0 +1,148 @@
+package kernel
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+// randomCells makes a random two state board
+func randomCells(height, width int) [][]uint8 {
+	cells := make([][]uint8, height)
+	for row := range cells {
+		cells[row] = make([]uint8, width)
+		for col := range cells[row] {
+			cells[row][col] = uint8(rand.Intn(2))
+		}
+	}
+	return cells
+}
+
+// randomHalo makes a halo of random cells for a worker to work out the next turn of
+func randomHalo(height, width int, topology stubs.Topology) stubs.Halo {
+	halo := stubs.Halo{
+		Board:    stubs.StateBoardFromSlice(randomCells(height+2, width), height+2, width, 2),
+		Offset:   1,
+		StartPtr: 0,
+		EndPtr:   height,
+		Topology: topology,
+	}
+	if topology == stubs.CrossSurface {
+		halo.Edges = stubs.StateBoardFromSlice(randomCells(height+2, 2), height+2, 2, 2)
+	}
+	return halo
+}
+
+// TestRowsRoundTrip checks packed rows encode and decode to the same cells as a slice
+func TestRowsRoundTrip(t *testing.T) {
+	for _, width := range []int{1, 7, 64, 65, 300} {
+		cells := randomCells(9, width)
+		fromSlice := stubs.StateBoardFromSlice(cells, 9, width, 2)
+		rows := fromSlice.Planes[0].DecodeRows(9, width)
+		fromRows := stubs.StateBoardFromRows(rows, 9, width)
+		if string(fromRows.Planes[0].Runs) != string(fromSlice.Planes[0].Runs) {
+			t.Fatalf("width %v: runs are encoded differently", width)
+		}
+		for row := range cells {
+			for col := range cells[row] {
+				if uint8(rows[row][col/64]>>uint(col%64)&1) != cells[row][col] {
+					t.Fatalf("width %v: cell (%v, %v) decoded wrongly", width, col, row)
+				}
+			}
+		}
+	}
+}
+
+// TestUpdateRows checks the bit-parallel kernel gives the same cells as UpdateRegion
+func TestUpdateRows(t *testing.T) {
+	topologies := []stubs.Topology{stubs.Torus, stubs.Plane, stubs.KleinBottle, stubs.CrossSurface}
+	for _, ruleString := range []string{"B3/S23", "B36/S23", "B0/S8", "B2/S"} {
+		rule, _ := stubs.ParseRule(ruleString)
+		for _, topology := range topologies {
+			for _, width := range []int{1, 5, 63, 64, 65, 200} {
+				t.Run(fmt.Sprintf("%v-%v-%v", ruleString, topology, width), func(t *testing.T) {
+					height := 12
+					halo := randomHalo(height, width, topology)
+					var edges [][]byte
+					if halo.Edges != nil {
+						edges = halo.Edges.Decode()
+					}
+					var wg sync.WaitGroup
+					wg.Add(2)
+					newBoard := make([][]uint8, height)
+					UpdateRegion(0, height, halo, newBoard, width, halo.Board.Decode(), edges, rule, stubs.TileMask{}, &wg)
+					newRows := make([][]uint64, height)
+					rows := halo.Board.Planes[0].DecodeRows(height+2, width)
+					UpdateRows(0, height, halo, newRows, rows, edges, rule, stubs.TileMask{}, &wg)
+
+					for row := range newBoard {
+						for col := range newBoard[row] {
+							if uint8(newRows[row][col/64]>>uint(col%64)&1) != newBoard[row][col] {
+								t.Fatalf("cell (%v, %v): expected %v", col, row, newBoard[row][col])
+							}
+						}
+						// Bits past the end of the row must be 0
+						if width%64 != 0 && newRows[row][len(newRows[row])-1]>>uint(width%64) != 0 {
+							t.Fatalf("row %v has bits set past its end", row)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestActiveTiles checks both kernels only work out active tiles, copying the rest, and agree on which tiles changed
+func TestActiveTiles(t *testing.T) {
+	rule, _ := stubs.ParseRule(stubs.DefaultRule)
+	height, width := 40, 200
+	halo := randomHalo(height, width, stubs.Torus)
+	board := halo.Board.Decode()
+	rows := halo.Board.Planes[0].DecodeRows(height+2, width)
+
+	// Work out every tile to compare against
+	var wg sync.WaitGroup
+	wg.Add(3)
+	allCells := make([][]uint8, height)
+	UpdateRegion(0, height, halo, allCells, width, board, nil, rule, stubs.TileMask{}, &wg)
+
+	// Only work out every other tile
+	active := stubs.NewTileMask(0, height, width)
+	for i := range active.Tiles {
+		active.Tiles[i] = i%2 == 0
+	}
+	newBoard := make([][]uint8, height)
+	UpdateRegion(0, height, halo, newBoard, width, board, nil, rule, active, &wg)
+	newRows := make([][]uint64, height)
+	UpdateRows(0, height, halo, newRows, rows, nil, rule, active, &wg)
+
+	for row := range newBoard {
+		for col := range newBoard[row] {
+			expected := allCells[row][col]
+			if !active.IsSet(row, col) {
+				expected = stubs.GetStateCell(board, height+2, width, row+1, col)
+			}
+			if newBoard[row][col] != expected {
+				t.Fatalf("UpdateRegion cell (%v, %v): expected %v", col, row, expected)
+			}
+			if uint8(newRows[row][col/64]>>uint(col%64)&1) != expected {
+				t.Fatalf("UpdateRows cell (%v, %v): expected %v", col, row, expected)
+			}
+		}
+	}
+
+	cellsChanged := ChangedCells(halo, newBoard, board)
+	rowsChanged := ChangedRows(halo, newRows, rows)
+	for i := range cellsChanged.Tiles {
+		if cellsChanged.Tiles[i] != rowsChanged.Tiles[i] {
+			t.Fatalf("tile %v: kernels disagree on whether it changed", i)
+		}
+		// Skipped tiles are copied, so they can't change
+		if cellsChanged.Tiles[i] && !active.Tiles[i] {
+			t.Fatalf("tile %v changed but wasn't active", i)
+		}
+	}
+}
@@ -23,14 +23,14 @@ func main() {
 	flag.IntVar(
 		&params.ImageWidth,
 		"w",
-		512,
-		"Specify the width of the image. Defaults to 512.")
+		0,
+		"Specify the width of the image. Defaults to the input file's width, or 512.")
 
 	flag.IntVar(
 		&params.ImageHeight,
 		"h",
-		512,
-		"Specify the height of the image. Defaults to 512.")
+		0,
+		"Specify the height of the image. Defaults to the input file's height, or 512.")
 
 	flag.IntVar(
 		&params.Turns,
@@ -50,6 +50,12 @@ func main() {
 		"port",
 		"8050",
 		"Specify our port. Defaults to 8050")
+	// Get the address the server can dial us back on from the commandline
+	flag.StringVar(
+		&params.OurIP,
+		"advertise",
+		"",
+		"Specify the address (host or host:port) the server can reach us on, if it can't use our connection. Defaults to the interface used to reach the server")
 
 	flag.BoolVar(&params.VisualUpdates,
 		"sdl",
@@ -60,13 +66,84 @@ func main() {
 		"resume",
 		false,
 		"Specify whether or not to resume the server's game")
+
+	flag.StringVar(&params.Session,
+		"session",
+		"",
+		"Specify the server session to resume. Defaults to the most recent one")
+
+	flag.BoolVar(&params.StopOnCycle,
+		"stop-on-cycle",
+		false,
+		"Specify whether to end the game early once the board is static or periodic")
+
+	flag.StringVar(&params.Rule,
+		"rule",
+		"B3/S23",
+		"Specify the Life-like rule in B/S notation. Defaults to B3/S23")
+
+	flag.StringVar(&params.Topology,
+		"topology",
+		"torus",
+		"Specify how the board edges join: torus, plane, klein or cross. Defaults to torus")
+
+	// -pattern was the old name for -input, so it still works
+	flag.StringVar(&params.Input,
+		"input",
+		"",
+		"Specify the board (.pgm) or pattern (.rle, .cells or .lif) file to start from. Defaults to images/WxH.pgm")
+	flag.StringVar(&params.Input,
+		"pattern",
+		"",
+		"Alias for -input")
+
+	flag.IntVar(&params.PatternX,
+		"px",
+		-1,
+		"Specify the column to place the pattern at. Defaults to centring it")
+
+	flag.IntVar(&params.PatternY,
+		"py",
+		-1,
+		"Specify the row to place the pattern at. Defaults to centring it")
+
+	flag.StringVar(&params.OutputFormat,
+		"format",
+		"pgm",
+		"Specify the format to save boards in: pgm, rle, cells or life106. Defaults to pgm")
+
+	// -o is a shorthand for -outdir
+	flag.StringVar(&params.OutputDir,
+		"outdir",
+		"out",
+		"Specify the directory to save boards in. Defaults to out")
+	flag.StringVar(&params.OutputDir,
+		"o",
+		"out",
+		"Shorthand for -outdir")
+
+	flag.BoolVar(&params.Offline,
+		"offline",
+		false,
+		"Specify whether to run the whole game here, without a server or workers")
 	flag.Parse()
 
+	// Find the board size if it wasn't given
+	width, height, err := gol.BoardSize(params)
+	if err != nil {
+		fmt.Println("Error reading board size:", err)
+		return
+	}
+	params.ImageWidth, params.ImageHeight = width, height
+
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
+	fmt.Println("Input:", params.Input)
 	fmt.Println("Server:", params.ServerAddress)
 	fmt.Println("RPC Port:", params.Port)
+	fmt.Println("Rule:", params.Rule)
+	fmt.Println("Topology:", params.Topology)
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
@@ -0,0 +1,74 @@
+package pattern
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ReadCells reads a plaintext (.cells) pattern
+// Lines starting with ! are comments, each other line is a row of the pattern with
+// . for dead cells and O (or *) for alive cells
+// Rows may leave off trailing dead cells
+func ReadCells(r io.Reader) (*Pattern, error) {
+	scanner := bufio.NewScanner(r)
+
+	// Read every row first, since we don't know the width until the end
+	rows := make([]string, 0)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		rows = append(rows, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	p, err := newPattern(width, len(rows))
+	if err != nil {
+		return nil, err
+	}
+	for y, row := range rows {
+		for x, c := range row {
+			switch c {
+			case '.':
+			case 'O', '*':
+				p.Cells[y][x] = 1
+			default:
+				return nil, errors.New("cells: unexpected character '" + string(c) + "'")
+			}
+		}
+	}
+	return p, nil
+}
+
+// WriteCells writes cells as a plaintext pattern
+// Every row is written in full, so boards keep their size and line up when compared
+// Only alive cells are written, dying cells from Generations rules are written as dead
+func WriteCells(w io.Writer, cells [][]uint8, name string) error {
+	out := bufio.NewWriter(w)
+	out.WriteString("!Name: " + name + "\n")
+	for _, row := range cells {
+		line := make([]byte, len(row))
+		for x, state := range row {
+			if state == 1 {
+				line[x] = 'O'
+			} else {
+				line[x] = '.'
+			}
+		}
+		out.Write(line)
+		out.WriteString("\n")
+	}
+	return out.Flush()
+}
@@ -0,0 +1,68 @@
+package pattern
+
+import (
+	"bytes"
+	"testing"
+)
+
+// borderedBoard makes a board with a glider away from its edges, so the rows and columns around it are empty
+func borderedBoard() [][]uint8 {
+	board := make([][]uint8, 10)
+	for row := range board {
+		board[row] = make([]uint8, 12)
+	}
+	board[3][5] = 1
+	board[4][6] = 1
+	board[5][4] = 1
+	board[5][5] = 1
+	board[5][6] = 1
+	return board
+}
+
+// assertSameCells checks two boards have the same cells
+func assertSameCells(t *testing.T, got, expected [][]uint8) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("got %v rows, expected %v", len(got), len(expected))
+	}
+	for row := range expected {
+		if len(got[row]) != len(expected[row]) {
+			t.Fatalf("row %v has %v cells, expected %v", row, len(got[row]), len(expected[row]))
+		}
+		for col := range expected[row] {
+			if got[row][col] != expected[row][col] {
+				t.Fatalf("cell (%v, %v) is %v, expected %v", col, row, got[row][col], expected[row][col])
+			}
+		}
+	}
+}
+
+// TestCellsRoundTrip checks a board saved as a plaintext pattern loads back with the same cells
+func TestCellsRoundTrip(t *testing.T) {
+	board := borderedBoard()
+	var file bytes.Buffer
+	if err := WriteCells(&file, board, "test"); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadCells(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Place(p, 12, 10, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCells(t, loaded, board)
+}
+
+// TestReadCells checks short rows are padded with dead cells and comments are skipped
+func TestReadCells(t *testing.T) {
+	p, err := ReadCells(bytes.NewBufferString("!Name: glider\n.O\n..O\nOOO\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCells(t, p.Cells, [][]uint8{{0, 1, 0}, {0, 0, 1}, {1, 1, 1}})
+	if p.Positioned {
+		t.Error("plaintext patterns shouldn't be positioned")
+	}
+}
@@ -0,0 +1,92 @@
+package pattern
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadLife106 reads a Life 1.06 pattern, which is a list of alive cell coordinates
+// The first line is "#Life 1.06", and each other line is an "x y" pair
+// The pattern is moved so its top left cell is at 0,0
+// If no coordinates are negative it is positioned where its top left cell was, so boards keep their positions
+func ReadLife106(r io.Reader) (*Pattern, error) {
+	scanner := bufio.NewScanner(r)
+
+	xs := make([]int, 0)
+	ys := make([]int, 0)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.New("life 1.06: expected \"x y\", got \"" + line + "\"")
+		}
+		x, xErr := strconv.Atoi(fields[0])
+		y, yErr := strconv.Atoi(fields[1])
+		if xErr != nil || yErr != nil {
+			return nil, errors.New("life 1.06: invalid coordinates \"" + line + "\"")
+		}
+		// Keep the bounding box small enough that working out its size can't overflow
+		if x < -MaxSize || x > MaxSize || y < -MaxSize || y > MaxSize {
+			return nil, errors.New("life 1.06: coordinates out of range \"" + line + "\"")
+		}
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Find the bounding box of the cells
+	minX, minY, maxX, maxY := 0, 0, -1, -1
+	if len(xs) > 0 {
+		minX, minY, maxX, maxY = xs[0], ys[0], xs[0], ys[0]
+	}
+	for i := range xs {
+		if xs[i] < minX {
+			minX = xs[i]
+		}
+		if ys[i] < minY {
+			minY = ys[i]
+		}
+		if xs[i] > maxX {
+			maxX = xs[i]
+		}
+		if ys[i] > maxY {
+			maxY = ys[i]
+		}
+	}
+
+	p, err := newPattern(maxX-minX+1, maxY-minY+1)
+	if err != nil {
+		return nil, err
+	}
+	for i := range xs {
+		p.Cells[ys[i]-minY][xs[i]-minX] = 1
+	}
+	if minX >= 0 && minY >= 0 {
+		p.Positioned = true
+		p.X, p.Y = minX, minY
+	}
+	return p, nil
+}
+
+// WriteLife106 writes the alive cells as a Life 1.06 coordinate list
+// Dying cells from Generations rules aren't alive, so aren't written
+func WriteLife106(w io.Writer, cells [][]uint8) error {
+	out := bufio.NewWriter(w)
+	out.WriteString("#Life 1.06\n")
+	for y, row := range cells {
+		for x, state := range row {
+			if state == 1 {
+				out.WriteString(strconv.Itoa(x) + " " + strconv.Itoa(y) + "\n")
+			}
+		}
+	}
+	return out.Flush()
+}
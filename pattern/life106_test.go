@@ -0,0 +1,94 @@
+package pattern
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestLife106RoundTrip checks a board saved as Life 1.06 loads back in the same place,
+// even though the rows and columns around the cells are empty
+func TestLife106RoundTrip(t *testing.T) {
+	board := borderedBoard()
+	var file bytes.Buffer
+	if err := WriteLife106(&file, board); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadLife106(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Place(p, 12, 10, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCells(t, loaded, board)
+}
+
+// TestLife106Negative checks patterns with negative coordinates are moved onto the board and centred
+func TestLife106Negative(t *testing.T) {
+	p, err := ReadLife106(bytes.NewBufferString("#Life 1.06\n-1 -1\n0 0\n1 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Positioned {
+		t.Error("patterns with negative coordinates shouldn't be positioned")
+	}
+	loaded, err := Place(p, 5, 5, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([][]uint8, 5)
+	for row := range expected {
+		expected[row] = make([]uint8, 5)
+		if row >= 1 && row <= 3 {
+			expected[row][row] = 1
+		}
+	}
+	assertSameCells(t, loaded, expected)
+}
+
+// TestPlaceTooBig checks placing a pattern that doesn't fit gives an error
+func TestPlaceTooBig(t *testing.T) {
+	p, err := ReadLife106(bytes.NewBufferString("#Life 1.06\n0 0\n7 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Place(p, 5, 5, -1, -1); err == nil {
+		t.Error("expected an error placing an 8 wide pattern on a 5 wide board")
+	}
+	if _, err := Place(p, 10, 5, 4, 0); err == nil {
+		t.Error("expected an error placing an 8 wide pattern at column 4 of a 10 wide board")
+	}
+}
+
+// TestLife106OnlyNegative checks a pattern with only negative coordinates is moved to 0,0, rather than padded out to the origin
+func TestLife106OnlyNegative(t *testing.T) {
+	p, err := ReadLife106(bytes.NewBufferString("#Life 1.06\n-10 -20\n-9 -20\n-10 -19\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Positioned {
+		t.Error("patterns with negative coordinates shouldn't be positioned")
+	}
+	assertSameCells(t, p.Cells, [][]uint8{{1, 1}, {1, 0}})
+}
+
+// TestLife106FarPositive checks a pattern far from the origin only holds its own cells, and is placed where the file says
+func TestLife106FarPositive(t *testing.T) {
+	p, err := ReadLife106(bytes.NewBufferString("#Life 1.06\n100 200\n101 201\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCells(t, p.Cells, [][]uint8{{1, 0}, {0, 1}})
+	loaded, err := Place(p, 128, 256, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([][]uint8, 256)
+	for row := range expected {
+		expected[row] = make([]uint8, 128)
+	}
+	expected[200][100] = 1
+	expected[201][101] = 1
+	assertSameCells(t, loaded, expected)
+}
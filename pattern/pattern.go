@@ -0,0 +1,116 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Pattern is a board read from a pattern file
+// Cells holds the state of every cell in the pattern's bounding box, indexed [y][x]
+// Rule is the rule string given in the file, and may be empty
+// Positioned is set if the file gives where the cells are on the board, so they are kept there
+// unless another position is asked for, and X and Y are then where the top left cell goes
+type Pattern struct {
+	Width      int
+	Height     int
+	Rule       string
+	Cells      [][]uint8
+	Positioned bool
+	X          int
+	Y          int
+}
+
+// MaxSize is the largest width or height of a pattern
+// Sizes come from the file, so they are checked before anything is allocated
+const MaxSize = 1 << 14
+
+// newPattern makes an empty pattern of the given size
+// Returns an error if the size is negative or larger than MaxSize
+func newPattern(width, height int) (*Pattern, error) {
+	if width < 0 || height < 0 || width > MaxSize || height > MaxSize {
+		return nil, fmt.Errorf("pattern: %dx%d is not a valid size, patterns can be at most %dx%d", width, height, MaxSize, MaxSize)
+	}
+	cells := make([][]uint8, height)
+	for row := range cells {
+		cells[row] = make([]uint8, width)
+	}
+	return &Pattern{Width: width, Height: height, Cells: cells}, nil
+}
+
+// CheckStates returns an error if any of a pattern's cells are in a state a rule with the given number of states doesn't have
+func CheckStates(p *Pattern, states int) error {
+	for y, row := range p.Cells {
+		for x, state := range row {
+			if int(state) >= states {
+				return fmt.Errorf("pattern: cell %d,%d is in state %d, but the rule only has %d states", x, y, state, states)
+			}
+		}
+	}
+	return nil
+}
+
+// Place puts a pattern on an empty board, with its top left corner at x, y
+// If x or y is negative, the pattern keeps its position from the file if it has one, otherwise it is centred
+// Returns an error if the pattern doesn't fit on the board
+func Place(p *Pattern, width, height, x, y int) ([][]uint8, error) {
+	if x < 0 {
+		x = (width - p.Width) / 2
+		if p.Positioned {
+			x = p.X
+		}
+	}
+	if y < 0 {
+		y = (height - p.Height) / 2
+		if p.Positioned {
+			y = p.Y
+		}
+	}
+	if x < 0 || y < 0 || x+p.Width > width || y+p.Height > height {
+		return nil, fmt.Errorf("pattern: %dx%d pattern at %d,%d doesn't fit on a %dx%d board", p.Width, p.Height, x, y, width, height)
+	}
+	board := make([][]uint8, height)
+	for row := range board {
+		board[row] = make([]uint8, width)
+		if row >= y && row < y+p.Height {
+			copy(board[row][x:], p.Cells[row-y])
+		}
+	}
+	return board, nil
+}
+
+// Extensions maps each output format name to the file extension it is saved with
+var Extensions = map[string]string{
+	"rle":     ".rle",
+	"cells":   ".cells",
+	"life106": ".lif",
+}
+
+// IsPatternFile returns true if the file has the extension of a pattern format
+func IsPatternFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".rle", ".cells", ".lif", ".life":
+		return true
+	}
+	return false
+}
+
+// ReadFile reads a pattern file, choosing the format from its extension
+func ReadFile(path string) (*Pattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch filepath.Ext(path) {
+	case ".rle":
+		return ReadRLE(file)
+	case ".cells":
+		return ReadCells(file)
+	case ".lif", ".life":
+		return ReadLife106(file)
+	}
+	return nil, errors.New("pattern: unknown format for " + path)
+}
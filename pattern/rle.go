@@ -0,0 +1,212 @@
+package pattern
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadRLE reads a run length encoded (.rle) pattern
+// The header line gives the size ("x = 3, y = 3, rule = B3/S23") and is followed by
+// runs of cells: b/. for dead, o for alive, A-X (optionally prefixed by p-y) for Generations states,
+// $ for the end of a row and ! for the end of the pattern
+func ReadRLE(r io.Reader) (*Pattern, error) {
+	scanner := bufio.NewScanner(r)
+
+	// Find the header line, skipping comments
+	var p *Pattern
+	for p == nil && scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		header, err := readRLEHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		p = header
+	}
+	if p == nil {
+		return nil, errors.New("rle: missing header line")
+	}
+
+	// Read the cells from the rest of the file
+	x, y := 0, 0
+	count := 0
+	prefix := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c >= '0' && c <= '9':
+				count = count*10 + int(c-'0')
+				continue
+			case c == ' ' || c == '\t' || c == '\r':
+				continue
+			case c == '!':
+				return p, nil
+			case c >= 'p' && c <= 'y' && i+1 < len(line) && line[i+1] >= 'A' && line[i+1] <= 'X':
+				// A prefix for states above 24
+				prefix = int(c-'p') + 1
+				continue
+			}
+
+			// Anything left is a run of some kind
+			run := count
+			if run == 0 {
+				run = 1
+			}
+			count = 0
+
+			if c == '$' {
+				// End of one or more rows
+				y += run
+				x = 0
+				continue
+			}
+
+			var state uint8
+			switch {
+			case c == 'b' || c == '.':
+				state = 0
+			case c >= 'A' && c <= 'X':
+				state = uint8(prefix*24 + int(c-'A') + 1)
+			case c >= 'a' && c <= 'z':
+				// Any other letter is alive in two state patterns
+				state = 1
+			default:
+				return nil, errors.New("rle: unexpected character '" + string(c) + "'")
+			}
+			prefix = 0
+
+			if x+run > p.Width || y >= p.Height {
+				return nil, errors.New("rle: cells outside the pattern's size")
+			}
+			for ; run > 0; run-- {
+				p.Cells[y][x] = state
+				x++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Some files leave off the final '!'
+	return p, nil
+}
+
+// readRLEHeader reads the "x = , y = , rule =" line and makes an empty pattern of that size
+func readRLEHeader(line string) (*Pattern, error) {
+	width, height := -1, -1
+	rule := ""
+	for _, field := range strings.Split(line, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("rle: invalid header \"" + line + "\"")
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		var err error
+		switch key {
+		case "x":
+			width, err = strconv.Atoi(value)
+		case "y":
+			height, err = strconv.Atoi(value)
+		case "rule":
+			rule = value
+		}
+		if err != nil {
+			return nil, errors.New("rle: invalid " + key + " in header")
+		}
+	}
+	if width < 0 || height < 0 {
+		return nil, errors.New("rle: header must give x and y")
+	}
+	p, err := newPattern(width, height)
+	if err != nil {
+		return nil, err
+	}
+	p.Rule = rule
+	return p, nil
+}
+
+// WriteRLE writes cells as a run length encoded pattern, including the rule line
+// Patterns with more than two states are written with Generations state letters
+func WriteRLE(w io.Writer, cells [][]uint8, rule string, states int) error {
+	height := len(cells)
+	width := 0
+	if height > 0 {
+		width = len(cells[0])
+	}
+	out := bufio.NewWriter(w)
+	header := "x = " + strconv.Itoa(width) + ", y = " + strconv.Itoa(height)
+	if rule != "" {
+		header += ", rule = " + rule
+	}
+	out.WriteString(header + "\n")
+
+	line := ""
+	// Add a run to the output, wrapping lines at 70 characters
+	addRun := func(run int, tag string) {
+		item := tag
+		if run > 1 {
+			item = strconv.Itoa(run) + tag
+		}
+		if len(line)+len(item) > 70 {
+			out.WriteString(line + "\n")
+			line = ""
+		}
+		line += item
+	}
+
+	// The row the output has got up to
+	lastRow := 0
+	for row := 0; row < height; row++ {
+		// Trailing dead cells in a row don't need to be written
+		end := width
+		for end > 0 && cells[row][end-1] == 0 {
+			end--
+		}
+		// Empty rows are skipped over by the next row's $ run
+		if end == 0 {
+			continue
+		}
+		if row > lastRow {
+			addRun(row-lastRow, "$")
+			lastRow = row
+		}
+
+		for col := 0; col < end; {
+			state := cells[row][col]
+			run := 1
+			for col+run < end && cells[row][col+run] == state {
+				run++
+			}
+			addRun(run, stateTag(state, states))
+			col += run
+		}
+	}
+	out.WriteString(line + "!\n")
+	return out.Flush()
+}
+
+// stateTag returns the letters used for a cell state in an RLE file
+func stateTag(state uint8, states int) string {
+	if states <= 2 {
+		if state == 0 {
+			return "b"
+		}
+		return "o"
+	}
+	if state == 0 {
+		return "."
+	}
+	tag := string(rune('A' + (int(state)-1)%24))
+	if state > 24 {
+		tag = string(rune('p'+(int(state)-1)/24-1)) + tag
+	}
+	return tag
+}
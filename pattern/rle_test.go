@@ -0,0 +1,90 @@
+package pattern
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestRLERoundTrip checks a board saved as RLE loads back with the same cells and rule
+func TestRLERoundTrip(t *testing.T) {
+	board := borderedBoard()
+	// A long row of cells, so it is written as a multi-digit run
+	for col := range board[8] {
+		board[8][col] = 1
+	}
+	var file bytes.Buffer
+	if err := WriteRLE(&file, board, "B3/S23", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(file.String(), "12o") {
+		t.Errorf("expected the full row to be written as a run, got %q", file.String())
+	}
+	p, err := ReadRLE(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Rule != "B3/S23" {
+		t.Errorf("rule is %q, expected B3/S23", p.Rule)
+	}
+	assertSameCells(t, p.Cells, board)
+}
+
+// TestRLEMultiDigitRuns checks runs of cells and rows longer than 9 are read in full
+func TestRLEMultiDigitRuns(t *testing.T) {
+	p, err := ReadRLE(strings.NewReader("x = 12, y = 13\n10bo$11bo11$12o!\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([][]uint8, 13)
+	for row := range expected {
+		expected[row] = make([]uint8, 12)
+	}
+	expected[0][10] = 1
+	expected[1][11] = 1
+	for col := range expected[12] {
+		expected[12][col] = 1
+	}
+	assertSameCells(t, p.Cells, expected)
+}
+
+// TestRLETermination checks nothing after the ! is read
+func TestRLETermination(t *testing.T) {
+	p, err := ReadRLE(strings.NewReader("#C a comment\nx = 3, y = 1\n3o!\nthis isn't part of the pattern $$$\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCells(t, p.Cells, [][]uint8{{1, 1, 1}})
+}
+
+// TestRLEGenerations checks Generations state letters, including prefixed ones, are read and written
+func TestRLEGenerations(t *testing.T) {
+	board := [][]uint8{{0, 1, 2, 2}, {3, 0, 25, 0}}
+	var file bytes.Buffer
+	if err := WriteRLE(&file, board, "345/2/30", 30); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(file.String(), ".A2B$C.pA") {
+		t.Errorf("expected state letters in the cells, got %q", file.String())
+	}
+	p, err := ReadRLE(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameCells(t, p.Cells, board)
+}
+
+// TestRLEBadHeader checks negative and oversized headers are rejected before the pattern is made
+func TestRLEBadHeader(t *testing.T) {
+	headers := []string{
+		"x = -1, y = 3",
+		"x = 3, y = -5",
+		"x = 100000000, y = 100000000",
+		"x = 3",
+	}
+	for _, header := range headers {
+		if _, err := ReadRLE(strings.NewReader(header + "\n!\n")); err == nil {
+			t.Errorf("expected an error for header %q", header)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package pgm reads and writes Netpbm greyscale (PGM) and bitmap (PBM) images.
+// Both the ASCII (P1, P2) and binary (P4, P5) forms are supported, including
+// comments in the header and any maxval up to 65535.
+package pgm
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Reader streams the pixels of an image, row by row
+// Pixel values are brightness, from 0 (black) to MaxVal (white)
+// PBM images have a MaxVal of 1 and are inverted, since a 1 bit in a PBM is black
+type Reader struct {
+	Format string
+	Width  int
+	Height int
+	MaxVal int
+
+	r *bufio.Reader
+	// read counts the pixels read so far
+	read int
+	// bits holds the rest of the current byte of a P4 image
+	bits     byte
+	bitsLeft uint
+}
+
+// NewReader reads the header of an image, leaving the reader ready to read pixels
+func NewReader(r io.Reader) (*Reader, error) {
+	p := &Reader{r: bufio.NewReader(r)}
+
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(p.r, magic); err != nil {
+		return nil, errors.New("pgm: missing header")
+	}
+	p.Format = string(magic)
+	switch p.Format {
+	case "P1", "P2", "P4", "P5":
+	default:
+		return nil, errors.New("pgm: not a pgm or pbm image")
+	}
+
+	var err error
+	if p.Width, err = p.readInt(); err != nil {
+		return nil, err
+	}
+	if p.Height, err = p.readInt(); err != nil {
+		return nil, err
+	}
+	if p.Format == "P1" || p.Format == "P4" {
+		p.MaxVal = 1
+	} else if p.MaxVal, err = p.readInt(); err != nil {
+		return nil, err
+	}
+	if p.Width <= 0 || p.Height <= 0 {
+		return nil, errors.New("pgm: invalid image size")
+	}
+	if p.MaxVal <= 0 || p.MaxVal > 65535 {
+		return nil, errors.New("pgm: maxval must be between 1 and 65535")
+	}
+
+	// Binary images have exactly one whitespace character before the pixels
+	// The pixel bytes can look like whitespace, so nothing else can be skipped
+	if p.Format == "P4" || p.Format == "P5" {
+		c, err := p.r.ReadByte()
+		if err != nil || !isSpace(c) {
+			return nil, errors.New("pgm: missing whitespace after header")
+		}
+	}
+	return p, nil
+}
+
+// isSpace returns true for the whitespace characters allowed in headers
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+// skipSpace skips whitespace and comments in the ASCII parts of the file
+func (p *Reader) skipSpace() error {
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == '#' {
+			// Comments run to the end of the line
+			if _, err := p.r.ReadString('\n'); err != nil {
+				return err
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return p.r.UnreadByte()
+		}
+	}
+}
+
+// readInt reads a decimal number from the ASCII parts of the file
+func (p *Reader) readInt() (int, error) {
+	if err := p.skipSpace(); err != nil {
+		return 0, errors.New("pgm: unexpected end of file")
+	}
+	digits := make([]byte, 0, 8)
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if c < '0' || c > '9' {
+			p.r.UnreadByte()
+			break
+		}
+		digits = append(digits, c)
+	}
+	n, err := strconv.Atoi(string(digits))
+	if err != nil {
+		return 0, errors.New("pgm: expected a number")
+	}
+	return n, nil
+}
+
+// ReadPixel returns the brightness of the next pixel, between 0 and MaxVal
+func (p *Reader) ReadPixel() (int, error) {
+	if p.read == p.Width*p.Height {
+		return 0, io.EOF
+	}
+	col := p.read % p.Width
+	p.read++
+
+	switch p.Format {
+	case "P1":
+		// Bits may or may not be separated by whitespace
+		if err := p.skipSpace(); err != nil {
+			return 0, errors.New("pgm: unexpected end of file")
+		}
+		c, _ := p.r.ReadByte()
+		if c != '0' && c != '1' {
+			return 0, errors.New("pgm: expected 0 or 1")
+		}
+		return int('1' - c), nil
+	case "P2":
+		v, err := p.readInt()
+		if err != nil {
+			return 0, err
+		}
+		if v > p.MaxVal {
+			return 0, errors.New("pgm: pixel above maxval")
+		}
+		return v, nil
+	case "P4":
+		// Each row starts on a new byte
+		if col == 0 || p.bitsLeft == 0 {
+			c, err := p.r.ReadByte()
+			if err != nil {
+				return 0, errors.New("pgm: unexpected end of file")
+			}
+			p.bits = c
+			p.bitsLeft = 8
+		}
+		p.bitsLeft--
+		return int(1 - (p.bits>>p.bitsLeft)&1), nil
+	default:
+		// P5 uses two bytes per pixel (most significant first) if maxval is above 255
+		c, err := p.r.ReadByte()
+		if err != nil {
+			return 0, errors.New("pgm: unexpected end of file")
+		}
+		v := int(c)
+		if p.MaxVal > 255 {
+			low, err := p.r.ReadByte()
+			if err != nil {
+				return 0, errors.New("pgm: unexpected end of file")
+			}
+			v = v<<8 | int(low)
+		}
+		if v > p.MaxVal {
+			return 0, errors.New("pgm: pixel above maxval")
+		}
+		return v, nil
+	}
+}
+
+// Grey scales a pixel value to a grey level from 0 to 255
+func (p *Reader) Grey(v int) uint8 {
+	return uint8((v*255 + p.MaxVal/2) / p.MaxVal)
+}
+
+// Writer streams the pixels of an image, row by row
+// Pixel values are brightness, as in Reader
+type Writer struct {
+	Format string
+	Width  int
+	Height int
+	MaxVal int
+
+	w *bufio.Writer
+	// written counts the pixels written so far
+	written int
+	// bits holds the current byte of a P4 image
+	bits    byte
+	numBits uint
+}
+
+// NewWriter writes the header of an image in the given format (P1, P2, P4 or P5)
+// The maxval is ignored for PBM images (P1 and P4)
+func NewWriter(w io.Writer, format string, width, height, maxval int) (*Writer, error) {
+	p := &Writer{Format: format, Width: width, Height: height, MaxVal: maxval, w: bufio.NewWriter(w)}
+	header := format + "\n" + strconv.Itoa(width) + " " + strconv.Itoa(height) + "\n"
+	switch format {
+	case "P1", "P4":
+		p.MaxVal = 1
+	case "P2", "P5":
+		if maxval <= 0 || maxval > 65535 {
+			return nil, errors.New("pgm: maxval must be between 1 and 65535")
+		}
+		header += strconv.Itoa(maxval) + "\n"
+	default:
+		return nil, errors.New("pgm: unknown format " + format)
+	}
+	if _, err := p.w.WriteString(header); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WritePixel writes the brightness of the next pixel, between 0 and MaxVal
+func (p *Writer) WritePixel(v int) error {
+	if p.written == p.Width*p.Height {
+		return errors.New("pgm: too many pixels")
+	}
+	if v < 0 || v > p.MaxVal {
+		return errors.New("pgm: pixel outside 0 to maxval")
+	}
+	p.written++
+	endOfRow := p.written%p.Width == 0
+
+	switch p.Format {
+	case "P1", "P2":
+		// One value per pixel, with a line for each row
+		value := strconv.Itoa(v)
+		if p.Format == "P1" {
+			value = strconv.Itoa(1 - v)
+		}
+		if endOfRow {
+			value += "\n"
+		} else {
+			value += " "
+		}
+		_, err := p.w.WriteString(value)
+		return err
+	case "P4":
+		// Pack bits into bytes, padding the end of each row
+		p.bits = p.bits<<1 | byte(1-v)
+		p.numBits++
+		if p.numBits == 8 || endOfRow {
+			p.bits <<= 8 - p.numBits
+			err := p.w.WriteByte(p.bits)
+			p.bits, p.numBits = 0, 0
+			return err
+		}
+		return nil
+	default:
+		if p.MaxVal > 255 {
+			if err := p.w.WriteByte(byte(v >> 8)); err != nil {
+				return err
+			}
+		}
+		return p.w.WriteByte(byte(v))
+	}
+}
+
+// Close flushes the image, and returns an error if not every pixel was written
+func (p *Writer) Close() error {
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	if p.written != p.Width*p.Height {
+		return errors.New("pgm: not all pixels were written")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package pgm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// readAll reads every pixel from an image
+func readAll(t *testing.T, data string) (*Reader, []int) {
+	image, err := NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels := make([]int, 0)
+	for i := 0; i < image.Width*image.Height; i++ {
+		v, err := image.ReadPixel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pixels = append(pixels, v)
+	}
+	return image, pixels
+}
+
+func assertPixels(t *testing.T, given, expected []int) {
+	if len(given) != len(expected) {
+		t.Fatalf("expected %v pixels, got %v", len(expected), len(given))
+	}
+	for i := range expected {
+		if given[i] != expected[i] {
+			t.Fatalf("pixel %v: expected %v, got %v", i, expected[i], given[i])
+		}
+	}
+}
+
+// TestBinaryWhitespace checks pixel bytes that look like whitespace are read as pixels
+func TestBinaryWhitespace(t *testing.T) {
+	data := "P5\n# a comment\n3 2\n255\n\x09\x0a\x0b\x0c\x0d\x20"
+	_, pixels := readAll(t, data)
+	assertPixels(t, pixels, []int{9, 10, 11, 12, 13, 32})
+}
+
+// TestFormats checks each format reads to the same brightness values
+func TestFormats(t *testing.T) {
+	tests := map[string]string{
+		"P1": "P1\n# bits\n3 2\n010\n1 0 1\n",
+		"P2": "P2\n3 2 # size\n7\n7 0 7\n0 7 0\n",
+		"P4": "P4\n3 2\n\x40\xa0",
+		"P5": "P5\n3 2\n1000\n\x03\xe8\x00\x00\x03\xe8\x00\x00\x03\xe8\x00\x00",
+	}
+	for format, data := range tests {
+		image, pixels := readAll(t, data)
+		for i := range pixels {
+			pixels[i] = int(image.Grey(pixels[i]))
+		}
+		t.Run(format, func(t *testing.T) {
+			assertPixels(t, pixels, []int{255, 0, 255, 0, 255, 0})
+		})
+	}
+}
+
+// TestRoundTrip checks images written by Writer are read back the same by Reader
+func TestRoundTrip(t *testing.T) {
+	width, height := 11, 3
+	for _, format := range []string{"P1", "P2", "P4", "P5"} {
+		t.Run(format, func(t *testing.T) {
+			expected := make([]int, 0)
+			var buf bytes.Buffer
+			image, err := NewWriter(&buf, format, width, height, 255)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < width*height; i++ {
+				v := (i * 37) % 256
+				if format == "P1" || format == "P4" {
+					v = i % 3 % 2
+				}
+				expected = append(expected, v)
+				if err := image.WritePixel(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := image.Close(); err != nil {
+				t.Fatal(err)
+			}
+			_, pixels := readAll(t, buf.String())
+			assertPixels(t, pixels, expected)
+		})
+	}
+}
+
+// TestErrors checks broken images return errors instead of panicking
+func TestErrors(t *testing.T) {
+	for _, data := range []string{"", "P6\n1 1\n255\n\x00", "P5\n2 2\n255\n\x00", "P5\n2 x\n255\n", "P2\n1 1\n10\n11\n"} {
+		image, err := NewReader(strings.NewReader(data))
+		if err != nil {
+			continue
+		}
+		for i := 0; i < image.Width*image.Height && err == nil; i++ {
+			_, err = image.ReadPixel()
+		}
+		if err == nil {
+			t.Errorf("expected an error reading %q", data)
+		}
+	}
+}
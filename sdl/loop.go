@@ -6,10 +6,15 @@ import (
 
 	"github.com/veandco/go-sdl2/sdl"
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 func Start(p gol.Params, events <-chan gol.Event, keyPresses chan<- rune) {
 	w := NewWindow(int32(p.ImageWidth), int32(p.ImageHeight))
+	// Generations rules have more than two states, which are drawn in shades of grey
+	rule, _ := stubs.ParseRule(p.Rule)
+	states := rule.NumStates()
 
 sdlLoop:
 	for {
@@ -29,6 +34,14 @@ sdlLoop:
 					keyPresses <- 'k'
 				case sdl.K_r:
 					keyPresses <- 'r'
+				// EXTENSION: step backwards and forwards through the history while paused
+				case sdl.K_LEFT:
+					keyPresses <- '<'
+				case sdl.K_RIGHT:
+					keyPresses <- '>'
+				// EXTENSION: step forward a single turn while paused
+				case sdl.K_n:
+					keyPresses <- 'n'
 				}
 			}
 		}
@@ -41,7 +54,7 @@ sdlLoop:
 			}
 			switch e := event.(type) {
 			case gol.CellFlipped:
-				w.FlipPixel(e.Cell.X, e.Cell.Y)
+				w.SetPixelGrey(e.Cell.X, e.Cell.Y, util.StateToGrey(e.State, states))
 			case gol.TurnComplete:
 				w.RenderFrame()
 			default:
@@ -1,5 +1,7 @@
 package stubs
 
+import "math/bits"
+
 // BitBoard stores a whole board using individual bits instead of bytes
 // This divides space required by 8
 // EXTENSION: bits are stored in a Run Length Encoded bit array
@@ -35,8 +37,8 @@ func GetBitArrayCell(bytes []byte, height, width int, row, col int) bool {
 
 // Decode "decodes" a RLE bit array to an array of bytes
 func (b *RLEBitArray) Decode() []byte {
-	// Array of bytes to store the bitarray
-	bytes := make([]byte, b.TotalBits/8)
+	// Array of bytes to store the bitarray, rounding up to a whole byte
+	bytes := make([]byte, (b.TotalBits+7)/8)
 	val := false
 	bit := uint(0)
 	// Loop through each run
@@ -135,3 +137,114 @@ func (b *BitBoard) ToSlice() [][]bool {
 	}
 	return newBoard
 }
+
+// EXTENSION: packed rows
+// Rows can also be stored as 64 bit words, so whole words of cells can be worked on at once
+// Bit i of word k in a row is the cell in column 64k+i, and any bits past the end of the row are 0
+
+// WordsPerRow returns the number of words needed to store a row of cells
+func WordsPerRow(width int) int {
+	return (width + 63) / 64
+}
+
+// DecodeRows decodes a RLE bit array straight into packed rows
+// Only runs of 1s need setting, so this is quicker than Decode for sparse boards
+func (b *RLEBitArray) DecodeRows(height, width int) [][]uint64 {
+	words := WordsPerRow(width)
+	rows := make([][]uint64, height)
+	for row := range rows {
+		rows[row] = make([]uint64, words)
+	}
+	val := false
+	bit := 0
+	for _, run := range b.Runs {
+		if val {
+			// Set the bits of the run, which may cross into the next rows
+			for r := 0; r < int(run); {
+				row, col := bit/width, bit%width
+				// Set as many bits as we can in this word
+				n := int(run) - r
+				if space := 64 - col%64; n > space {
+					n = space
+				}
+				if space := width - col; n > space {
+					n = space
+				}
+				rows[row][col/64] |= (^uint64(0) >> uint(64-n)) << uint(col%64)
+				r += n
+				bit += n
+			}
+		} else {
+			bit += int(run)
+		}
+		val = !val
+	}
+	return rows
+}
+
+// addRun is used when constructing the RLEBitArray
+// It adds a number of identical bits onto the end of the array, encoding them the same as addBit would
+func (b *RLEBitArray) addRun(val bool, length int) {
+	if length <= 0 {
+		return
+	}
+	if len(b.Runs) == 0 {
+		// Runs start with 0 bits, so add a 0-length run first
+		if val {
+			b.Runs = append(b.Runs, 0)
+		}
+		b.Runs = append(b.Runs, 0)
+	} else if val != b.lastBit {
+		b.Runs = append(b.Runs, 0)
+	}
+	b.lastBit = val
+	for length > 0 {
+		last := len(b.Runs) - 1
+		// If we've maxed out the run length, start a new run (skipping one)
+		if b.Runs[last] == 255 {
+			b.Runs = append(b.Runs, 0, 0)
+			last += 2
+		}
+		n := 255 - int(b.Runs[last])
+		if n > length {
+			n = length
+		}
+		b.Runs[last] += byte(n)
+		length -= n
+	}
+}
+
+// StateBoardFromRows will construct a two state StateBoard from packed rows
+// Runs of cells are found a word at a time, so this is quicker than StateBoardFromSlice
+func StateBoardFromRows(rows [][]uint64, height, width int) *StateBoard {
+	stateBoard := new(StateBoard)
+	stateBoard.RowLength = width
+	stateBoard.NumRows = height
+	stateBoard.Planes = make([]RLEBitArray, 1)
+	plane := &stateBoard.Planes[0]
+	plane.TotalBits = uint(height * width)
+
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; {
+			word := rows[row][col/64] >> uint(col%64)
+			// Bits past the end of the row are 0, so don't count them
+			left := width - col
+			if left > 64-col%64 {
+				left = 64 - col%64
+			}
+			// Find how long the run of bits the same as this one is
+			var n int
+			if word&1 == 0 {
+				n = bits.TrailingZeros64(word)
+			} else {
+				n = bits.TrailingZeros64(^word)
+			}
+			if n > left {
+				n = left
+			}
+			plane.addRun(word&1 == 1, n)
+			col += n
+		}
+	}
+	return stateBoard
+}
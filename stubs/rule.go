@@ -0,0 +1,130 @@
+package stubs
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Rule stores a Life-like rule in B/S notation (e.g. B3/S23 for Conway's Game of Life)
+// Birth and Survive are bitmasks indexed by the number of alive neighbours
+// If bit n of Birth is set, a dead cell with n alive neighbours comes alive
+// If bit n of Survive is set, an alive cell with n alive neighbours stays alive
+// EXTENSION: States is the number of cell states for Generations rules (e.g. B2/S/C3)
+// Alive cells that don't survive pass through States-2 dying states before they are dead
+type Rule struct {
+	Birth   uint16
+	Survive uint16
+	States  int
+}
+
+// DefaultRule is the rule string for Conway's Game of Life
+const DefaultRule = "B3/S23"
+
+// ParseRule reads a rule string and returns the Rule it represents
+// Both B/S notation ("B36/S23") and the older S/B notation ("23/36") are accepted
+// Generations rules add a third part with the number of states ("B2/S/C3" or "/2/3")
+func ParseRule(rule string) (Rule, error) {
+	parsed := Rule{States: 2}
+	parts := strings.Split(strings.TrimSpace(rule), "/")
+	if len(parts) == 3 {
+		// Read the number of states, which may have a C or G in front
+		states, err := strconv.Atoi(strings.TrimLeft(strings.ToUpper(parts[2]), "CG"))
+		if err != nil || states < 2 || states > 256 {
+			return parsed, errors.New("number of states must be between 2 and 256")
+		}
+		parsed.States = states
+		parts = parts[:2]
+	}
+	if len(parts) != 2 {
+		return parsed, errors.New("rule must have two or three parts separated by '/'")
+	}
+
+	// Work out which part is birth and which is survival
+	var birth, survive string
+	first := strings.ToUpper(parts[0])
+	second := strings.ToUpper(parts[1])
+	switch {
+	case strings.HasPrefix(first, "B") && strings.HasPrefix(second, "S"):
+		birth, survive = first[1:], second[1:]
+	case strings.HasPrefix(first, "S") && strings.HasPrefix(second, "B"):
+		survive, birth = first[1:], second[1:]
+	case !strings.ContainsAny(first+second, "BS"):
+		// S/B notation with no letters, survival comes first
+		survive, birth = first, second
+	default:
+		return parsed, errors.New("rule must be in the form B<digits>/S<digits>")
+	}
+
+	var err error
+	parsed.Birth, err = neighbourMask(birth)
+	if err != nil {
+		return parsed, err
+	}
+	parsed.Survive, err = neighbourMask(survive)
+	if err != nil {
+		return parsed, err
+	}
+	return parsed, nil
+}
+
+// neighbourMask converts a list of digits into a bitmask of neighbour counts
+func neighbourMask(digits string) (uint16, error) {
+	mask := uint16(0)
+	for _, d := range digits {
+		if d < '0' || d > '8' {
+			return 0, errors.New("invalid neighbour count '" + string(d) + "', must be 0-8")
+		}
+		mask |= 1 << uint(d-'0')
+	}
+	return mask, nil
+}
+
+// Next applies the rule to a cell, given its state and its number of alive neighbours
+// Returns the next state of the cell
+func (r Rule) Next(state uint8, neighbours int) uint8 {
+	switch state {
+	case 0:
+		// Dead cells can be born
+		if r.Birth&(1<<uint(neighbours)) != 0 {
+			return 1
+		}
+		return 0
+	case 1:
+		// Alive cells either survive or start dying
+		if r.Survive&(1<<uint(neighbours)) != 0 {
+			return 1
+		}
+	}
+	// Dying cells always move on to the next state, and are dead after the last one
+	return uint8((int(state) + 1) % r.NumStates())
+}
+
+// NumStates returns the number of states a cell can be in
+// This is 2 (dead and alive) for standard Life-like rules
+func (r Rule) NumStates() int {
+	if r.States < 2 {
+		return 2
+	}
+	return r.States
+}
+
+// String returns the rule in B/S notation
+func (r Rule) String() string {
+	rule := "B" + maskDigits(r.Birth) + "/S" + maskDigits(r.Survive)
+	if r.NumStates() > 2 {
+		rule += "/C" + strconv.Itoa(r.States)
+	}
+	return rule
+}
+
+// maskDigits converts a bitmask of neighbour counts back into a list of digits
+func maskDigits(mask uint16) string {
+	digits := ""
+	for n := uint(0); n <= 8; n++ {
+		if mask&(1<<n) != 0 {
+			digits += string(rune('0' + n))
+		}
+	}
+	return digits
+}
@@ -0,0 +1,106 @@
+package stubs
+
+import "hash/fnv"
+
+// StateBoard stores a whole board where each cell can be in one of several states
+// EXTENSION: this allows Generations rules, where dying cells pass through decay states
+// State 0 is dead, state 1 is alive, and any higher states are dying
+// Each bit of the cell states is stored as its own RLE bit array (a bit plane),
+// so a two state board takes exactly the same space as a BitBoard
+type StateBoard struct {
+	RowLength int
+	NumRows   int
+	Planes    []RLEBitArray
+}
+
+// planesForStates returns the number of bits needed to store a cell with this many states
+func planesForStates(states int) int {
+	planes := 1
+	for (1 << uint(planes)) < states {
+		planes++
+	}
+	return planes
+}
+
+// StateBoardFromSlice will construct a StateBoard from a 2d slice of cell states
+func StateBoardFromSlice(board [][]uint8, height, width int, states int) *StateBoard {
+	// Allocate a new stateboard
+	stateBoard := new(StateBoard)
+	stateBoard.RowLength = width
+	stateBoard.NumRows = height
+	stateBoard.Planes = make([]RLEBitArray, planesForStates(states))
+
+	// Add each bit of every cell to its plane
+	for p := range stateBoard.Planes {
+		plane := &stateBoard.Planes[p]
+		plane.TotalBits = uint(height * width)
+		for row := 0; row < height; row++ {
+			for col := 0; col < width; col++ {
+				plane.addBit(board[row][col]&(1<<uint(p)) != 0)
+			}
+		}
+	}
+
+	return stateBoard
+}
+
+// Decode decodes every plane of the board into bit arrays
+// Cells can then be read with GetStateCell
+func (b *StateBoard) Decode() [][]byte {
+	planes := make([][]byte, len(b.Planes))
+	for p := range b.Planes {
+		planes[p] = b.Planes[p].Decode()
+	}
+	return planes
+}
+
+// GetStateCell returns the state of a cell in decoded planes as if they were a 2d slice
+func GetStateCell(planes [][]byte, height, width int, row, col int) uint8 {
+	state := uint8(0)
+	for p := range planes {
+		if GetBitArrayCell(planes[p], height, width, row, col) {
+			state |= 1 << uint(p)
+		}
+	}
+	return state
+}
+
+// IsAliveCell returns true if a cell in decoded planes is alive (in state 1)
+// This is quicker than calling GetStateCell when only alive cells matter
+func IsAliveCell(planes [][]byte, height, width int, row, col int) bool {
+	if !GetBitArrayCell(planes[0], height, width, row, col) {
+		return false
+	}
+	// Any higher bits mean the cell is dying
+	for p := 1; p < len(planes); p++ {
+		if GetBitArrayCell(planes[p], height, width, row, col) {
+			return false
+		}
+	}
+	return true
+}
+
+// ToSlice unpacks a stateboard back to a 2d slice of cell states
+func (b *StateBoard) ToSlice() [][]uint8 {
+	// Create the new board 2d slice
+	newBoard := make([][]uint8, b.NumRows)
+	// Decode the RLE bits
+	planes := b.Decode()
+	// Set each cell in the new board
+	for row := 0; row < b.NumRows; row++ {
+		newBoard[row] = make([]uint8, b.RowLength)
+		for col := 0; col < b.RowLength; col++ {
+			newBoard[row][col] = GetStateCell(planes, b.NumRows, b.RowLength, row, col)
+		}
+	}
+	return newBoard
+}
+
+// HashRow hashes a row of cell states along with its row number
+// Adding up the hashes of every row gives a hash of the board which is the same however the board is split up
+func HashRow(row int, cells []uint8) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{byte(row), byte(row >> 8), byte(row >> 16), byte(row >> 24)})
+	h.Write(cells)
+	return h.Sum64()
+}
@@ -6,17 +6,23 @@ package stubs
 type Fragment struct {
 	StartRow int
 	EndRow   int
-	BitBoard *BitBoard
+	Board    *StateBoard
 }
 
 // Halo is a subset of a board containing all the cells required to calculate the next turn cells
 // between two parts of the board
-// It stores the board state using a BitBoard, to save space
+// It stores the board state using a StateBoard, to save space
+// The first and last rows are the cells just off the edge of the fragment, already
+// mapped through the board's topology
 type Halo struct {
-	BitBoard *BitBoard
+	Board    *StateBoard
 	Offset   int
 	StartPtr int
 	EndPtr   int
+	Topology Topology
+	// Edges holds the cells just off the left and right of each halo row
+	// It is only set for topologies where these aren't in the same row (the cross-surface)
+	Edges *StateBoard
 }
 
 
@@ -50,6 +56,7 @@ var ServerStartGame = "Server.StartGame"
 var ServerRegisterKeypress = "Server.RegisterKeypress"
 var ServerConnectWorker = "Server.ConnectWorker"
 var ServerPing = "Server.Ping"
+var ServerRunTurns = "Server.RunTurns"
 
 // Controller RPC strings
 var ControllerGameStateChange = "Controller.GameStateChange"
@@ -57,10 +64,20 @@ var ControllerTurnComplete = "Controller.TurnComplete"
 var ControllerFinalTurnComplete = "Controller.FinalTurnComplete"
 var ControllerSaveBoard = "Controller.SaveBoard"
 var ControllerReportAliveCells = "Controller.ReportAliveCells"
+var ControllerCycleDetected = "Controller.CycleDetected"
 
 // Worker RPC strings
 var WorkerDoTurn = "Worker.DoTurn"
 var WorkerShutdown = "Worker.Shutdown"
+var WorkerLoadStrip = "Worker.LoadStrip"
+var WorkerStepStrip = "Worker.StepStrip"
+var WorkerGetStrip = "Worker.GetStrip"
+var WorkerDropStrip = "Worker.DropStrip"
+var WorkerGetEdge = "Worker.GetEdge"
+
+// CallbackPreamble starts a connection opened for the server to make calls back on, followed by a token and a newline
+// Controllers and workers send the token with StartGame or ConnectWorker, so the server doesn't have to dial them
+var CallbackPreamble = "GOL-CALLBACK "
 
 // ServerResponse contains a result from a standard server RPC call
 // Success indicates if the call executed its desired function
@@ -68,6 +85,8 @@ var WorkerShutdown = "Worker.Shutdown"
 type ServerResponse struct {
 	Success bool
 	Message string
+	// SessionID is set by StartGame to the ID of the session the game is running in
+	SessionID string
 }
 
 // StartGameRequest contains all data required for a controller to connect to a server
@@ -76,26 +95,52 @@ type ServerResponse struct {
 // and the starting board state
 type StartGameRequest struct {
 	ControllerAddress string
+	// Callback is the token of the connection the controller opened for us to call it back on
+	// If it is empty, or the connection can't be found, the controller is dialled at its address
+	Callback string
 
 	Height        int
 	Width         int
 	MaxTurns      int
 	Threads       int
 	VisualUpdates bool
+	// Rule is the Life-like rule string to play by, in B/S notation
+	Rule string
+	// Topology is the name of the board topology (see ParseTopology)
+	Topology string
 
 	StartNew bool
-	Board    *BitBoard
+	Board    *StateBoard
+	// SessionID is the session to resume when StartNew is false
+	// If it is empty, the most recent session is resumed
+	SessionID string
+	// StopOnCycle ends the game early once the board is static or periodic
+	StopOnCycle bool
 }
 
 // KeypressRequest is used to send a keypress from a controller to be handled at the server
 type KeypressRequest struct {
 	Key rune
+	// SessionID is the session the controller's game is running in
+	SessionID string
+}
+
+// RunTurnsRequest is used to run a number of turns and then pause again
+type RunTurnsRequest struct {
+	Turns int
+	// SessionID is the session to run the turns in
+	SessionID string
 }
 
 // WorkerConnectRequest is passed by a worker which wishes to connect to the server
 // This contains the address of the worker so the server can establish a connection
 type WorkerConnectRequest struct {
 	WorkerAddress string
+	// Callback is the token of the connection the worker opened for us to call it on
+	// If it is empty, or the connection can't be found, the worker is dialled at its address
+	Callback string
+	// Cores is the number of CPU cores the worker has, so faster workers can be given more rows
+	Cores int
 }
 
 // StateChangeReport is passed to the controller to inform them of changes to game state
@@ -114,7 +159,9 @@ type TurnCompleteReport struct {
 type BoardStateReport struct {
 	CompletedTurns int
 
-	Board *BitBoard
+	Board *StateBoard
+	// Tiles counts the tiles worked out and skipped on this turn
+	Tiles TileStats
 }
 
 // AliveCellsReport is passed to the controller every 2 seconds to tell them how many
@@ -122,6 +169,19 @@ type BoardStateReport struct {
 type AliveCellsReport struct {
 	CompletedTurns int
 	NumAlive       int
+	// Tiles counts the tiles worked out and skipped since the last report
+	Tiles TileStats
+	// Speculations counts the fragments sent to a second worker since the last report, because the first was too slow
+	Speculations int
+}
+
+// CycleReport is passed to the controller when the board becomes static or periodic
+// The board on StartTurn is the same as the board on StartTurn+Period
+// A Period of 1 means the board is a still life
+type CycleReport struct {
+	CompletedTurns int
+	StartTurn      int
+	Period         int
 }
 
 // DoTurnRequest is passed to workers to ask them to calculate the next turn
@@ -129,11 +189,112 @@ type AliveCellsReport struct {
 type DoTurnRequest struct {
 	Halo    Halo
 	Threads int
+	Rule    Rule
+	// Active is the tiles of the fragment that need working out, the rest can be copied from the halo
+	Active TileMask
 }
 
 // DoTurnResponse is returned by workers to the server containing a fragment of the new board
 type DoTurnResponse struct {
 	Frag Fragment
+	// Changed is the tiles of the fragment that have a cell which changed
+	Changed TileMask
+}
+
+// EXTENSION: persistent strips
+// Workers keep their strip of the board between turns, so only the rows around it are sent each turn
+
+// LoadStripRequest is passed to a worker to give it a strip of the board to keep
+// A worker can keep more than one strip of a session's board, so strips are known by their first row
+// Any strip it already has for the session starting at the same row is replaced
+type LoadStripRequest struct {
+	SessionID string
+	Strip     Fragment
+	Rule      Rule
+	Topology  Topology
+	Threads   int
+	// Turn is the turn the strip is on
+	Turn int
+	// Replace drops every other strip the worker has of the session, since the board is being shared out again
+	Replace bool
+
+	// EXTENSION: peer-to-peer halo exchange
+	// If Peers is set, the rows just off the top and bottom of the strip are fetched from the workers
+	// with those rows each turn, rather than being sent by the server
+	// Above or Below is nil if the row is off the edge of the board, so it is always dead
+	Peers bool
+	Above *StripPeer
+	Below *StripPeer
+}
+
+// StripPeer says where to get a row from, when workers send rows to each other
+type StripPeer struct {
+	Address string
+	// Start is the first row of the peer's strip
+	Start int
+	// Bottom is set if the row is the peer's last row, rather than its first
+	Bottom bool
+	// Mirror is set if the row should be reversed, because of the topology
+	Mirror bool
+}
+
+// EdgeRequest is passed between workers to get the first or last row of a strip
+type EdgeRequest struct {
+	SessionID string
+	// Start is the first row of the strip
+	Start int
+	// Turn is the turn to get the row from, the strip may already be a turn ahead of this
+	Turn   int
+	Bottom bool
+	Mirror bool
+}
+
+// StepStripRequest is passed to a worker to move its strip on a turn
+// Above and Below are single rows, the cells just off the top and bottom of the strip
+// They aren't sent if the worker gets them from its peers
+type StepStripRequest struct {
+	SessionID string
+	// Start is the first row of the strip
+	Start int
+	// Turn is the turn the strip is on before it is moved on
+	Turn  int
+	Above *StateBoard
+	Below *StateBoard
+	// Edges holds the cells just off the left and right of each row, the same as a halo's
+	Edges *StateBoard
+	// Active is the tiles of the strip that need working out
+	Active TileMask
+	// Hash asks the worker for the hash of its new strip, for cycle detection
+	Hash bool
+	// Pull asks the worker for the rows of its new strip that are in a row of tiles that changed,
+	// so the server can keep its board up to date without getting the whole strip
+	Pull bool
+}
+
+// StepStripResponse is returned by a worker once its strip has moved on a turn
+// Top and Bottom are the new first and last rows of the strip, which the strips next to it need
+// They aren't sent if the workers get them from each other
+type StepStripResponse struct {
+	Top    *StateBoard
+	Bottom *StateBoard
+	// Sides holds the new first and last cell of each row, it is only set on a cross-surface
+	Sides *StateBoard
+	// Changed is the tiles of the strip that have a cell which changed
+	Changed TileMask
+	// Hash is the sum of HashRow for every row of the strip
+	Hash uint64
+	// LostPeer is the address of a worker we couldn't get a row from, the strip hasn't moved if it is set
+	LostPeer string
+	// Rows holds the rows given by Changed.RowsSet, from top to bottom, if Pull was set
+	// It is nil if none of them changed
+	Rows *StateBoard
+}
+
+// StripRequest is passed to a worker to get or drop its strip of a session's board
+type StripRequest struct {
+	SessionID string
+	// Start is the first row of the strip
+	Start int
 }
 
 // Empty is used when there is no information for an RPC function to return
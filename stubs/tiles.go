@@ -0,0 +1,82 @@
+package stubs
+
+// EXTENSION: tiles
+// The board is split into tiles, and tiles are only worked out if a cell in or next to them changed last turn
+// Anything else can't change this turn either, so it is copied over
+
+// TileWidth and TileHeight are the size of a tile in cells
+// Tiles are a whole word of cells wide, so the bit-parallel kernel can skip them a word at a time
+const (
+	TileWidth  = 64
+	TileHeight = 16
+)
+
+// TileMask has a flag for each tile in some rows of tiles
+// It is used for the tiles that need working out, and the tiles that changed
+type TileMask struct {
+	// FirstRow is the row of tiles the mask starts on
+	FirstRow int
+	// Columns is the number of tiles across the board
+	Columns int
+	// Tiles has a flag for each tile, row by row
+	// If it is nil, every tile is set
+	Tiles []bool
+}
+
+// TileStats counts the tiles which were worked out and the tiles which were skipped
+type TileStats struct {
+	Computed int
+	Skipped  int
+}
+
+// TileColumns returns the number of tiles across a row of cells
+func TileColumns(width int) int {
+	return (width + TileWidth - 1) / TileWidth
+}
+
+// TileRows returns the number of tiles down a column of cells
+func TileRows(height int) int {
+	return (height + TileHeight - 1) / TileHeight
+}
+
+// IsSet returns true if the flag is set for the tile holding a cell on the board
+func (m TileMask) IsSet(row, col int) bool {
+	if m.Tiles == nil {
+		return true
+	}
+	return m.Tiles[(row/TileHeight-m.FirstRow)*m.Columns+col/TileWidth]
+}
+
+// Set sets the flag for the tile holding a cell on the board
+// The mask must have been made with Tiles set
+func (m TileMask) Set(row, col int) {
+	m.Tiles[(row/TileHeight-m.FirstRow)*m.Columns+col/TileWidth] = true
+}
+
+// RowsSet returns the board rows from start to end that are in a row of tiles with a flag set
+// If Tiles is nil, every row is returned
+func (m TileMask) RowsSet(start, end int) []int {
+	rows := make([]int, 0)
+	for row := start; row < end; row++ {
+		if m.Tiles == nil {
+			rows = append(rows, row)
+			continue
+		}
+		first := (row/TileHeight - m.FirstRow) * m.Columns
+		for _, set := range m.Tiles[first : first+m.Columns] {
+			if set {
+				rows = append(rows, row)
+				break
+			}
+		}
+	}
+	return rows
+}
+
+// NewTileMask makes a mask with no tiles set, covering the rows of tiles that hold board rows start to end
+func NewTileMask(start, end, width int) TileMask {
+	firstRow := start / TileHeight
+	rows := (end-1)/TileHeight - firstRow + 1
+	columns := TileColumns(width)
+	return TileMask{FirstRow: firstRow, Columns: columns, Tiles: make([]bool, rows*columns)}
+}
@@ -0,0 +1,80 @@
+package stubs
+
+import "errors"
+
+// Topology describes how the edges of the board are joined together
+type Topology int
+
+const (
+	// Torus joins left to right and top to bottom (the standard Game of Life board)
+	Torus Topology = iota
+	// Plane doesn't join any edges, cells off the board are always dead
+	Plane
+	// KleinBottle joins left to right, and top to bottom with a twist
+	KleinBottle
+	// CrossSurface joins both pairs of edges with a twist (a projective plane)
+	CrossSurface
+)
+
+// ParseTopology returns the topology with the given name
+// An empty name is treated as a torus
+func ParseTopology(name string) (Topology, error) {
+	switch name {
+	case "", "torus":
+		return Torus, nil
+	case "plane":
+		return Plane, nil
+	case "klein":
+		return KleinBottle, nil
+	case "cross":
+		return CrossSurface, nil
+	}
+	return Torus, errors.New("unknown topology, must be one of torus, plane, klein or cross")
+}
+
+// String returns the name of the topology as accepted by ParseTopology
+func (t Topology) String() string {
+	switch t {
+	case Torus:
+		return "torus"
+	case Plane:
+		return "plane"
+	case KleinBottle:
+		return "klein"
+	case CrossSurface:
+		return "cross"
+	default:
+		return "Incorrect Topology"
+	}
+}
+
+// Wrap maps a cell that is at most one cell off the edge of the board back onto the board
+// Returns false if the cell isn't on the board, meaning it is always dead
+func (t Topology) Wrap(x, y, width, height int) (int, int, bool) {
+	offX := x < 0 || x >= width
+	offY := y < 0 || y >= height
+	switch t {
+	case Plane:
+		if offX || offY {
+			return x, y, false
+		}
+	case KleinBottle:
+		// Crossing the top or bottom edge mirrors the column
+		if offY {
+			x = width - 1 - x
+		}
+	case CrossSurface:
+		// The corners of a cross-surface are singular points, so diagonals through them are dead
+		if offX && offY {
+			return x, y, false
+		}
+		// Crossing an edge mirrors the position along the other axis
+		if offY {
+			x = width - 1 - x
+		}
+		if offX {
+			y = height - 1 - y
+		}
+	}
+	return (x + width) % width, (y + height) % height, true
+}
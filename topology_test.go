@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestTopology tests 16x16 and 64x64 images on 1 and 100 turns on each non-torus topology.
+// Expected boards are in check/topology/<topology>
+func TestTopology(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+	}
+	for _, topology := range []string{"plane", "klein", "cross"} {
+		for _, p := range tests {
+			for _, turns := range []int{1, 100} {
+				p.Turns = turns
+				p.Topology = topology
+				expectedAlive := util.ReadAliveCells(
+					"check/topology/"+topology+"/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+					p.ImageWidth,
+					p.ImageHeight,
+				)
+				for threads := 1; threads <= 4; threads++ {
+					p.Threads = threads
+					testName := fmt.Sprintf("%s-%dx%dx%d-%d", topology, p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+					t.Run(testName, func(t *testing.T) {
+						events := make(chan gol.Event)
+						gol.Run(p, events, nil)
+						var cells []util.Cell
+						for event := range events {
+							switch e := event.(type) {
+							case gol.FinalTurnComplete:
+								cells = e.Alive
+							}
+						}
+						assertEqualBoard(t, cells, expectedAlive, p)
+					})
+				}
+			}
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package util
 
 import (
-	"io/ioutil"
-	"strconv"
-	"strings"
+	"os"
+
+	"uk.ac.bris.cs/gameoflife/pattern"
+	"uk.ac.bris.cs/gameoflife/pgm"
 )
 
 // Cell is used as the return type for the testing framework.
@@ -12,13 +13,14 @@ type Cell struct {
 }
 
 // GetAliveCells returns all the alive cells in a board
-func GetAliveCells(board [][]bool) []Cell {
+// Cells in dying states (from Generations rules) don't count as alive
+func GetAliveCells(board [][]uint8) []Cell {
 	height := len(board)
 	width := len(board[0])
 	aliveCells := make([]Cell, 0)
 	for row := 0; row < height; row++ {
 		for col := 0; col < width; col++ {
-			if board[row][col] == true {
+			if board[row][col] == 1 {
 				aliveCells = append(aliveCells, Cell{X: col, Y: row})
 			}
 		}
@@ -26,46 +28,58 @@ func GetAliveCells(board [][]bool) []Cell {
 	return aliveCells
 }
 
+// ReadAliveCells reads the alive cells from a pgm image or a pattern file (.rle, .cells or .lif)
+// The image is read as a two state board
 func ReadAliveCells(path string, width, height int) []Cell {
+	return ReadAliveCellsStates(path, width, height, 2)
+}
+
+// ReadAliveCellsStates reads the alive cells from a board for a rule with the given number of states
+// Grey levels are turned into states the same way as when a game is started from the image,
+// so cells in dying states don't count as alive
+func ReadAliveCellsStates(path string, width, height, states int) []Cell {
+	if pattern.IsPatternFile(path) {
+		return readPatternAliveCells(path, width, height)
+	}
+
 	//data, ioError := ioutil.ReadFile("check/images/" + fmt.Sprintf("%vx%vx%v.pgm", width, height, turns))
-	data, ioError := ioutil.ReadFile(path)
+	file, ioError := os.Open(path)
 	Check(ioError)
+	defer file.Close()
 
-	fields := strings.Fields(string(data))
-
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
-	}
+	image, ioError := pgm.NewReader(file)
+	Check(ioError)
 
-	imageWidth, _ := strconv.Atoi(fields[1])
-	if imageWidth != width {
+	if image.Width != width {
 		panic("Incorrect width")
 	}
 
-	imageHeight, _ := strconv.Atoi(fields[2])
-	if imageHeight != height {
+	if image.Height != height {
 		panic("Incorrect height")
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
-	}
-
-	image := []byte(fields[4])
-
 	var cells []Cell
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			cell := image[0]
-			if cell != 0 {
+			v, ioError := image.ReadPixel()
+			Check(ioError)
+			if GreyToState(image.Grey(v), states) == 1 {
 				cells = append(cells, Cell{
 					X: x,
 					Y: y,
 				})
 			}
-			image = image[1:]
 		}
 	}
 	return cells
 }
+
+// readPatternAliveCells reads the alive cells from a pattern file
+// The pattern is placed on the board the same way as when a game is started from it
+func readPatternAliveCells(path string, width, height int) []Cell {
+	p, err := pattern.ReadFile(path)
+	Check(err)
+	board, err := pattern.Place(p, width, height, -1, -1)
+	Check(err)
+	return GetAliveCells(board)
+}
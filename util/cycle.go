@@ -0,0 +1,85 @@
+package util
+
+import (
+	"uk.ac.bris.cs/gameoflife/stubs"
+)
+
+/////////
+
+// EXTENSION: cycle detection
+// Each turn's board is hashed, so we can tell when a game becomes static (a still life) or periodic
+// Only the hashes of recent turns are kept, so cycles longer than the window aren't found
+// It is used by the server, and by the controller when it plays offline
+
+/////////
+
+// CycleDetector remembers the board hashes of recent consecutive turns
+type CycleDetector struct {
+	// hashes is a ring buffer of the recent hashes, indexed by turn
+	hashes []uint64
+	// turns maps each hash in the ring to the most recent turn it was seen on
+	turns map[uint64]int
+	// firstTurn and lastTurn are the turns of the oldest and newest hashes in the ring
+	firstTurn int
+	lastTurn  int
+	empty     bool
+}
+
+// NewCycleDetector makes a cycle detector that can find cycles up to size turns long
+func NewCycleDetector(size int) *CycleDetector {
+	return &CycleDetector{
+		hashes: make([]uint64, size),
+		turns:  make(map[uint64]int),
+		empty:  true,
+	}
+}
+
+// HashBoard hashes a board
+// Collisions are unlikely enough with 64 bits that matching hashes are treated as matching boards
+// The hash is a sum of row hashes, so workers can hash their own strips of the board
+func HashBoard(board [][]uint8) uint64 {
+	hash := uint64(0)
+	for row := range board {
+		hash += stubs.HashRow(row, board[row])
+	}
+	return hash
+}
+
+// Reset forgets every hash, e.g. when the board is randomised
+func (c *CycleDetector) Reset() {
+	c.turns = make(map[uint64]int)
+	c.empty = true
+}
+
+// Add adds the hash of the board for a turn
+// If the board was seen on an earlier turn, returns the turn the cycle started on and its period
+// A period of 1 means the board is a still life
+// If the turn doesn't follow on from the last one, the detector starts again from this turn
+func (c *CycleDetector) Add(turn int, hash uint64) (start int, period int, found bool) {
+	size := len(c.hashes)
+	if size == 0 {
+		return 0, 0, false
+	}
+	if c.empty || turn != c.lastTurn+1 {
+		c.Reset()
+		c.firstTurn = turn
+		c.empty = false
+	} else if turn-c.firstTurn == size {
+		// Forget the oldest turn if the ring is full
+		oldest := c.hashes[c.firstTurn%size]
+		if c.turns[oldest] == c.firstTurn {
+			delete(c.turns, oldest)
+		}
+		c.firstTurn++
+	}
+	c.lastTurn = turn
+
+	c.hashes[turn%size] = hash
+	// Every earlier turn has been checked, so the first repeat is where the cycle starts
+	previous, seen := c.turns[hash]
+	c.turns[hash] = turn
+	if seen {
+		return previous, turn - previous, true
+	}
+	return 0, 0, false
+}
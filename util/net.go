@@ -1,23 +1,59 @@
 package util
 
 import (
-	"io/ioutil"
-	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/rpc"
+
+	"uk.ac.bris.cs/gameoflife/stubs"
 )
 
-// GetPublicIp fetches our public IP and returns it as a string
-func GetPublicIP() string {
-	url := "https://api.ipify.org?format=text"
-	println("Getting IP address from  ipify")
-	resp, err := http.Get(url)
+// GetOutboundIP finds the IP address of the network interface we use to reach an address
+// Nothing is sent, so this works without internet access
+// If the address can't be reached, localhost is used
+func GetOutboundIP(address string) string {
+	// Dialling UDP only picks a route, it doesn't send any packets
+	conn, err := net.Dial("udp", address)
 	if err != nil {
-		panic(err)
+		println("Can't find a route to", address, "using localhost:", err.Error())
+		return "127.0.0.1"
 	}
-	defer resp.Body.Close()
-	ip, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		panic(err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// AdvertisedAddress gets the address others should connect to us on, when we are listening on a port
+// The advertised address may be a host or a host and port, if it is empty the interface we use to reach
+// the server is used
+func AdvertisedAddress(advertise, port, serverAddress string) string {
+	if advertise == "" {
+		return net.JoinHostPort(GetOutboundIP(serverAddress), port)
+	}
+	if _, _, err := net.SplitHostPort(advertise); err == nil {
+		return advertise
 	}
-	return string(ip)
+	return net.JoinHostPort(advertise, port)
+}
 
+// DialCallback opens a connection to the server for it to call us back on, rather than it dialling us
+// Calls from the server are handled by rpcServer until the connection is closed
+// Returns the connection, and the token to send the server so it can find the connection
+func DialCallback(serverAddress string, rpcServer *rpc.Server) (net.Conn, string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(bytes)
+	conn, err := net.Dial("tcp", serverAddress)
+	if err != nil {
+		return nil, "", err
+	}
+	// Tell the server this connection is for it to make calls on
+	if _, err := conn.Write([]byte(stubs.CallbackPreamble + token + "\n")); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	go rpcServer.ServeConn(conn)
+	return conn, token, nil
 }
@@ -106,3 +106,25 @@ func squaresToStrings(given, expected [][]uint8, width, height int) []string {
 
 	return output
 }
+
+// StateToGrey maps a cell state to a grey level for images and the SDL window
+// Dead cells are black, alive cells are white and dying cells fade towards black
+func StateToGrey(state uint8, states int) uint8 {
+	if state == 0 {
+		return 0
+	}
+	return uint8(255 - ((int(state)-1)*255+(states-1)/2)/(states-1))
+}
+
+// GreyToState maps a grey level from an image back to the nearest cell state
+// This is the inverse of StateToGrey
+func GreyToState(grey uint8, states int) uint8 {
+	if grey == 0 {
+		return 0
+	}
+	state := 1 + ((255-int(grey))*(states-1)+127)/255
+	if state > states-1 {
+		state = states - 1
+	}
+	return uint8(state)
+}